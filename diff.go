@@ -0,0 +1,81 @@
+package kvstructure
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// diff collects the writes of a run, which are compared
+// to the existing keys before they are applied
+type diff struct {
+	mu sync.Mutex
+
+	// puts are the values by key
+	puts map[string][]byte
+
+	// cleared are the keys of which the trees are deleted
+	cleared []string
+}
+
+// newDiff creates an empty diff
+func newDiff() *diff {
+	return &diff{
+		puts: make(map[string][]byte),
+	}
+}
+
+// put records the value of the key
+func (d *diff) put(key string, value []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.puts[key] = value
+}
+
+// clear records that the tree of the key is deleted
+func (d *diff) clear(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.cleared = append(d.cleared, key)
+}
+
+// isCleared reports whether the key is in a deleted tree
+func (d *diff) isCleared(key string) bool {
+	for _, c := range d.cleared {
+		if key == c || strings.HasPrefix(key, trailingSlash(c)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// changes returns the keys which have to be written, because their value
+// differs from the existing value, and the existing keys which have to be deleted,
+// because they are in a deleted tree or are pruned, and are not written
+func (d *diff) changes(existing map[string][]byte, pruned func(string) bool) ([]string, []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	puts := make([]string, 0)
+	for key, value := range d.puts {
+		if old, ok := existing[key]; ok && string(old) == string(value) {
+			continue
+		}
+		puts = append(puts, key)
+	}
+
+	deletes := make([]string, 0)
+	for key := range existing {
+		if _, ok := d.puts[key]; !ok && (d.isCleared(key) || pruned(key)) {
+			deletes = append(deletes, key)
+		}
+	}
+
+	sort.Strings(puts)
+	sort.Strings(deletes)
+
+	return puts, deletes
+}
@@ -0,0 +1,154 @@
+package kvstructure
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// encodings are the tag options that set the encoding of a field
+var encodings = []string{"json", "yaml", "base64", "hex", "csv", "raw"}
+
+// encodeValue encodes the value of a field with the encoding
+func encodeValue(enc string, val reflect.Value) ([]byte, error) {
+	switch enc {
+	case "json":
+		return json.Marshal(val.Interface())
+	case "yaml":
+		return yaml.Marshal(val.Interface())
+	case "base64", "hex", "raw":
+		b, err := rawBytes(val)
+		if err != nil {
+			return nil, err
+		}
+
+		switch enc {
+		case "base64":
+			return []byte(base64.StdEncoding.EncodeToString(b)), nil
+		case "hex":
+			return []byte(hex.EncodeToString(b)), nil
+		}
+
+		return b, nil
+	case "csv":
+		if val.Kind() != reflect.Slice && val.Kind() != reflect.Array {
+			return nil, fmt.Errorf("csv encoding of unsupported type '%s'", val.Type())
+		}
+
+		record := make([]string, val.Len())
+		for i := range record {
+			s, err := formatValue(val.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			record[i] = s
+		}
+
+		var buf bytes.Buffer
+
+		w := csv.NewWriter(&buf)
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+		w.Flush()
+
+		return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), w.Error()
+	}
+
+	return nil, fmt.Errorf("unknown encoding '%s'", enc)
+}
+
+// decodeValue decodes the raw value of a field with the encoding. The elements
+// of csv encoded values are returned as raw values, which are decoded by the caller.
+func decodeValue(enc string, b []byte, val reflect.Value) ([]string, error) {
+	switch enc {
+	case "json":
+		return nil, json.Unmarshal(b, val.Addr().Interface())
+	case "yaml":
+		return nil, yaml.Unmarshal(b, val.Addr().Interface())
+	case "base64", "hex", "raw":
+		var err error
+
+		switch enc {
+		case "base64":
+			b, err = base64.StdEncoding.DecodeString(string(b))
+		case "hex":
+			b, err = hex.DecodeString(string(b))
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, setRawBytes(val, b)
+	case "csv":
+		if val.Kind() != reflect.Slice && val.Kind() != reflect.Array {
+			return nil, fmt.Errorf("csv encoding of unsupported type '%s'", val.Type())
+		}
+
+		if len(b) == 0 {
+			return []string{}, nil
+		}
+
+		return csv.NewReader(bytes.NewReader(b)).Read()
+	}
+
+	return nil, fmt.Errorf("unknown encoding '%s'", enc)
+}
+
+// rawBytes returns the bytes of a string or a byte slice
+func rawBytes(val reflect.Value) ([]byte, error) {
+	switch {
+	case val.Kind() == reflect.String:
+		return []byte(val.String()), nil
+	case val.Kind() == reflect.Slice && val.Type().Elem().Kind() == reflect.Uint8:
+		return val.Bytes(), nil
+	}
+
+	return nil, fmt.Errorf("raw encoding of unsupported type '%s'", val.Type())
+}
+
+// setRawBytes sets a string or a byte slice to the bytes
+func setRawBytes(val reflect.Value, b []byte) error {
+	switch {
+	case val.Kind() == reflect.String:
+		val.SetString(string(b))
+	case val.Kind() == reflect.Slice && val.Type().Elem().Kind() == reflect.Uint8:
+		val.SetBytes(b)
+	default:
+		return fmt.Errorf("raw encoding of unsupported type '%s'", val.Type())
+	}
+
+	return nil
+}
+
+// formatValue returns the string representation of a scalar value
+func formatValue(val reflect.Value) (string, error) {
+	if m, ok := val.Interface().(encoding.TextMarshaler); ok {
+		b, err := m.MarshalText()
+		return string(b), err
+	}
+
+	switch getKind(val) {
+	case reflect.String:
+		return val.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(val.Bool()), nil
+	case reflect.Int:
+		return strconv.FormatInt(val.Int(), 10), nil
+	case reflect.Uint:
+		return strconv.FormatUint(val.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(val.Float(), 'g', -1, val.Type().Bits()), nil
+	}
+
+	return "", fmt.Errorf("csv encoding of unsupported type '%s'", val.Type())
+}
@@ -0,0 +1,225 @@
+package kvstructure
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrRequired is the cause of an error if nothing is stored at the key
+	// of a field with the required option
+	ErrRequired = errors.New("required key is missing")
+
+	// ErrUnknownKey is the cause of an error if a key has not been decoded
+	// in strict mode
+	ErrUnknownKey = errors.New("unknown key")
+)
+
+// Error is an error that happened while transcoding or transdecoding
+// the value of a key. The cause of the error can be inspected with
+// errors.Is and errors.As, e.g. errors.Is(err, store.ErrKeyNotFound).
+type Error struct {
+	// Key is the key in the kv, including the prefix
+	Key string
+
+	// Field is the path to the value in the Go value, e.g. "Backends[a].Port".
+	// It is empty if the error happened at the root of the value.
+	Field string
+
+	// Type is the type of the value
+	Type reflect.Type
+
+	// Value is the raw value in the kv, if it has been read
+	Value []byte
+
+	// Err is the underlying cause of the error
+	Err error
+}
+
+// Error returns the string representation of the error
+func (e *Error) Error() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "kvstructure: '%s'", e.Key)
+
+	if e.Field != "" {
+		fmt.Fprintf(&b, " (field '%s')", e.Field)
+	}
+
+	if e.Type != nil {
+		fmt.Fprintf(&b, " of type '%s'", e.Type)
+	}
+
+	if e.Value != nil {
+		fmt.Fprintf(&b, " with value '%s'", e.Value)
+	}
+
+	fmt.Fprintf(&b, ": %s", e.Err)
+
+	return b.String()
+}
+
+// Unwrap returns the underlying cause of the error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// MultiError contains all errors that happened while transcoding or transdecoding,
+// if the errors are collected instead of stopping at the first error.
+type MultiError struct {
+	Errors []error
+}
+
+// Error returns the string representation of all errors
+func (e *MultiError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = "\t* " + err.Error()
+	}
+
+	return fmt.Sprintf("kvstructure: %d error(s) occurred:\n%s", len(e.Errors), strings.Join(msgs, "\n"))
+}
+
+// Is reports whether any of the errors matches the target
+func (e *MultiError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// As finds the first error that matches the target
+func (e *MultiError) As(target interface{}) bool {
+	for _, err := range e.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SliceError is returned if elements of a slice failed to transdecode,
+// while the other elements have been kept.
+type SliceError struct {
+	// Key is the key of the slice in the kv, including the prefix
+	Key string
+
+	// Indices are the indexes of the elements that failed
+	Indices []int
+
+	// Errors are the errors of the elements that failed
+	Errors []error
+}
+
+// Error returns the string representation of the error
+func (e *SliceError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = "\t* " + err.Error()
+	}
+
+	return fmt.Sprintf("kvstructure: '%s' failed at %v:\n%s", e.Key, e.Indices, strings.Join(msgs, "\n"))
+}
+
+// Is reports whether any of the errors matches the target
+func (e *SliceError) Is(target error) bool {
+	return (&MultiError{Errors: e.Errors}).Is(target)
+}
+
+// As finds the first error that matches the target
+func (e *SliceError) As(target interface{}) bool {
+	return (&MultiError{Errors: e.Errors}).As(target)
+}
+
+// newError wraps the error with the key, the type and the raw value.
+// Errors which are wrapped already are returned as is.
+func newError(key string, typ reflect.Type, value []byte, err error) error {
+	switch err.(type) {
+	case nil, *Error, *MultiError, *SliceError:
+		return err
+	}
+
+	return &Error{
+		Key:   key,
+		Type:  typ,
+		Value: value,
+		Err:   err,
+	}
+}
+
+// withType sets the type of the error, if it is not known yet
+func withType(err error, typ reflect.Type) error {
+	if e, ok := err.(*Error); ok && e.Type == nil {
+		e.Type = typ
+	}
+
+	return err
+}
+
+// withField prefixes the field path of the errors with the field,
+// which is either a struct field name or an index like "[0]"
+func withField(err error, field string) error {
+	switch e := err.(type) {
+	case *Error:
+		switch {
+		case e.Field == "":
+			e.Field = field
+		case strings.HasPrefix(e.Field, "["):
+			e.Field = field + e.Field
+		default:
+			e.Field = field + "." + e.Field
+		}
+	case *MultiError:
+		for _, err := range e.Errors {
+			withField(err, field)
+		}
+	case *SliceError:
+		for _, err := range e.Errors {
+			withField(err, field)
+		}
+	}
+
+	return err
+}
+
+// errorList collects errors concurrently
+type errorList struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+// add adds the error to the list, nested lists are flattened
+func (l *errorList) add(err error) {
+	if err == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e, ok := err.(*MultiError); ok {
+		l.errs = append(l.errs, e.Errors...)
+		return
+	}
+
+	l.errs = append(l.errs, err)
+}
+
+// err returns nil if no error has been collected, or a MultiError
+func (l *errorList) err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.errs) == 0 {
+		return nil
+	}
+
+	return &MultiError{Errors: l.errs}
+}
@@ -1,9 +1,10 @@
 module github.com/andersnormal/kvstructure
 
-go 1.12
+go 1.13
 
 require (
 	github.com/docker/libkv v0.2.2-0.20180912205406-458977154600
 	github.com/stretchr/testify v1.4.0
 	golang.org/x/sync v0.0.0-20190423024810-112230192c58
+	gopkg.in/yaml.v2 v2.2.2
 )
@@ -1,9 +1,139 @@
 package kvstructure
 
 import (
+	"context"
+	"encoding"
+	"reflect"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libkv/store"
+)
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	timeType            = reflect.TypeOf(time.Time{})
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	kvMarshalerType     = reflect.TypeOf((*KVMarshaler)(nil)).Elem()
+	kvUnmarshalerType   = reflect.TypeOf((*KVUnmarshaler)(nil)).Elem()
 )
 
+// marshalsKV reports whether values of the type write their own keys
+func marshalsKV(typ reflect.Type) bool {
+	return typ.Kind() != reflect.Interface &&
+		(typ.Implements(kvMarshalerType) || reflect.PtrTo(typ).Implements(kvMarshalerType))
+}
+
+// unmarshalsKV reports whether values of the type read their own keys
+func unmarshalsKV(typ reflect.Type) bool {
+	return typ.Kind() != reflect.Interface && reflect.PtrTo(typ).Implements(kvUnmarshalerType)
+}
+
+// isText reports whether values of the type are written to and read from
+// a single key in their text representation. The type has to implement both
+// directions, so that its values are read the same way as they are written.
+func isText(typ reflect.Type) bool {
+	if typ == durationType || typ == timeType {
+		return true
+	}
+
+	return typ.Kind() != reflect.Interface &&
+		(typ.Implements(textMarshalerType) || reflect.PtrTo(typ).Implements(textMarshalerType)) &&
+		reflect.PtrTo(typ).Implements(textUnmarshalerType)
+}
+
+// addrInterface returns a pointer to the value if it is addressable,
+// so that methods with a pointer receiver can be called. It returns nil
+// for values which are read-only, e.g. reached through unexported embedded fields.
+func addrInterface(val reflect.Value) interface{} {
+	if !val.CanInterface() {
+		return nil
+	}
+
+	if val.CanAddr() {
+		return val.Addr().Interface()
+	}
+
+	return val.Interface()
+}
+
+// skipField reports whether the field is neither transcoded nor transdecoded.
+// Unexported fields are skipped, unless they are embedded structs that are
+// squashed, as only their exported fields are used.
+func skipField(field reflect.StructField, tag string, opts tagOptions) bool {
+	if tag == "-" {
+		return true
+	}
+
+	if field.PkgPath == "" {
+		return false
+	}
+
+	typ := field.Type
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	return !field.Anonymous || !opts.squash() || typ.Kind() != reflect.Struct
+}
+
+// promotedHook reports whether the hook method of the struct type is promoted
+// from an embedded struct, which gets its own hook call as it is not squashed.
+// The hook is then only called on the embedded struct, and not twice.
+func promotedHook(typ reflect.Type, method string, tagName string) bool {
+	m, ok := typ.MethodByName(method)
+	if !ok {
+		m, ok = reflect.PtrTo(typ).MethodByName(method)
+	}
+
+	if !ok {
+		return false
+	}
+
+	// promoted methods are called through wrappers generated by the compiler
+	pc := m.Func.Pointer()
+	if file, _ := runtime.FuncForPC(pc).FileLine(pc); file != "<autogenerated>" {
+		return false
+	}
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.Anonymous {
+			continue
+		}
+
+		tag, opts := parseTag(field.Tag.Get(tagName))
+		if skipField(field, tag, opts) || opts.squash() || opts.encoding() != "" {
+			continue
+		}
+
+		ft := field.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		if _, ok := reflect.PtrTo(ft).MethodByName(method); ok && ft.Kind() == reflect.Struct {
+			return true
+		}
+	}
+
+	return false
+}
+
+// contextError returns the error of the context wrapped with the key
+// that has been reached, if the context is done
+func contextError(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return &Error{Key: key, Err: err}
+	}
+
+	return nil
+}
+
 // leadingSlash is adding a slash to the beginning
 func leadingSlash(s string) string {
 	prefix := "/"
@@ -21,3 +151,148 @@ func trailingSlash(s string) string {
 	}
 	return s
 }
+
+// inTree reports whether the key is the parent or below it. Some kvs list
+// by the raw prefix, which also returns siblings like "foobar" for "foo".
+func inTree(key, parent string) bool {
+	return key == parent || strings.HasPrefix(key, trailingSlash(parent))
+}
+
+// child is a direct descendant of a key in the kv
+type child struct {
+	// name is the path segment of the child below the key
+	name string
+
+	// kvPair is set if the child is a leaf
+	kvPair *store.KVPair
+}
+
+// children groups the pairs below the key by their first path segment.
+// The order is the one in which the segments were first seen.
+func children(key string, kvPairs []*store.KVPair) []*child {
+	key = trailingSlash(key)
+
+	seen := make(map[string]*child)
+	cc := make([]*child, 0)
+
+	for _, kvPair := range kvPairs {
+		if !strings.HasPrefix(kvPair.Key, key) {
+			continue
+		}
+
+		rel := strings.TrimPrefix(kvPair.Key, key)
+		if rel == "" {
+			continue
+		}
+
+		segs := strings.SplitN(rel, "/", 2)
+
+		c, ok := seen[segs[0]]
+		if !ok {
+			c = &child{name: segs[0]}
+			seen[segs[0]] = c
+			cc = append(cc, c)
+		}
+
+		if len(segs) == 1 {
+			c.kvPair = kvPair
+		}
+	}
+
+	return cc
+}
+
+// genericTree builds a generic value from the pairs below the key.
+// Leaves are strings, and nodes of which the children are exactly the indexes
+// 0..n-1 are slices. Any other node is a map[string]interface{}.
+// It returns nil if there are no pairs below the key.
+func genericTree(key string, kvPairs []*store.KVPair) interface{} {
+	cc := children(key, kvPairs)
+	if len(cc) == 0 {
+		return nil
+	}
+
+	m := make(map[string]interface{}, len(cc))
+	for _, c := range cc {
+		if c.kvPair != nil {
+			m[c.name] = string(c.kvPair.Value)
+			continue
+		}
+
+		m[c.name] = genericTree(trailingSlash(key)+c.name, kvPairs)
+	}
+
+	s := make([]interface{}, len(m))
+	for i := range s {
+		v, ok := m[strconv.Itoa(i)]
+		if !ok {
+			return m
+		}
+		s[i] = v
+	}
+
+	return s
+}
+
+// isEmptyValue reports whether the value is the zero value of its type,
+// or an empty array, map, slice or string
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !isEmptyValue(v.Field(i)) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// keySet is a set of keys that is safe for concurrent use
+type keySet struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+// newKeySet returns an empty set
+func newKeySet() *keySet {
+	return &keySet{keys: make(map[string]struct{})}
+}
+
+// add adds the key to the set, and reports whether it is new
+func (s *keySet) add(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[key]; ok {
+		return false
+	}
+	s.keys[key] = struct{}{}
+
+	return true
+}
+
+// has reports whether the key is in the set
+func (s *keySet) has(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.keys[key]
+
+	return ok
+}
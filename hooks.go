@@ -0,0 +1,124 @@
+package kvstructure
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var ipType = reflect.TypeOf(net.IP{})
+
+// ComposeDecodeHookFunc returns a hook that calls the hooks in order.
+// The result of a hook is passed to the next hook as long as it is
+// a raw value, i.e. a []byte or a string.
+func ComposeDecodeHookFunc(fs ...DecodeHookFunc) DecodeHookFunc {
+	return func(from []byte, to reflect.Type) (interface{}, error) {
+		var data interface{} = from
+
+		for _, f := range fs {
+			if f == nil {
+				continue
+			}
+
+			raw, ok := rawValue(data)
+			if !ok {
+				break
+			}
+
+			v, err := f(raw, to)
+			if err != nil {
+				return nil, err
+			}
+
+			if v != nil {
+				data = v
+			}
+		}
+
+		return data, nil
+	}
+}
+
+// StringToSliceHookFunc splits the value of a slice or an array,
+// which is stored at a single key, by the separator
+func StringToSliceHookFunc(sep string) DecodeHookFunc {
+	return func(from []byte, to reflect.Type) (interface{}, error) {
+		if (to.Kind() != reflect.Slice && to.Kind() != reflect.Array) || to.Elem().Kind() == reflect.Uint8 {
+			return from, nil
+		}
+
+		if len(from) == 0 {
+			return []string{}, nil
+		}
+
+		return strings.Split(string(from), sep), nil
+	}
+}
+
+// StringToTimeDurationHookFunc parses the value of a time.Duration
+func StringToTimeDurationHookFunc() DecodeHookFunc {
+	return func(from []byte, to reflect.Type) (interface{}, error) {
+		if to != durationType {
+			return from, nil
+		}
+
+		return time.ParseDuration(string(from))
+	}
+}
+
+// StringToIPHookFunc parses the value of a net.IP
+func StringToIPHookFunc() DecodeHookFunc {
+	return func(from []byte, to reflect.Type) (interface{}, error) {
+		if to != ipType {
+			return from, nil
+		}
+
+		ip := net.ParseIP(string(from))
+		if ip == nil {
+			return nil, fmt.Errorf("failed parsing ip '%s'", from)
+		}
+
+		return ip, nil
+	}
+}
+
+// ExpandEnvHookFunc replaces ${var} or $var in the value
+// by the value of the environment variable
+func ExpandEnvHookFunc() DecodeHookFunc {
+	return func(from []byte, to reflect.Type) (interface{}, error) {
+		return []byte(os.ExpandEnv(string(from))), nil
+	}
+}
+
+// rawValue returns the bytes of a raw value that is returned by a hook
+func rawValue(v interface{}) ([]byte, bool) {
+	switch v := v.(type) {
+	case []byte:
+		return v, true
+	case string:
+		return []byte(v), true
+	}
+
+	return nil, false
+}
+
+// setHookValue sets the value that is returned by a hook
+func setHookValue(val reflect.Value, v interface{}) error {
+	rv := reflect.ValueOf(v)
+
+	switch {
+	case !rv.IsValid():
+		return fmt.Errorf("decode hook returned nil for '%s'", val.Type())
+	case rv.Type().AssignableTo(val.Type()):
+		val.Set(rv)
+	case rv.Kind() == val.Kind() && rv.Type().ConvertibleTo(val.Type()):
+		val.Set(rv.Convert(val.Type()))
+	default:
+		return fmt.Errorf("decode hook returned '%s' for '%s'", rv.Type(), val.Type())
+	}
+
+	return nil
+}
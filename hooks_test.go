@@ -0,0 +1,85 @@
+package kvstructure_test
+
+import (
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	. "github.com/andersnormal/kvstructure"
+	"github.com/andersnormal/kvstructure/memstore"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComposeDecodeHookFunc(t *testing.T) {
+	os.Setenv("KVSTRUCTURE_HOSTS", "a,b")
+	defer os.Unsetenv("KVSTRUCTURE_HOSTS")
+
+	f := ComposeDecodeHookFunc(
+		ExpandEnvHookFunc(),
+		StringToSliceHookFunc(","),
+	)
+
+	v, err := f([]byte("${KVSTRUCTURE_HOSTS},c"), reflect.TypeOf([]string{}))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, v)
+
+	v, err = f([]byte("$KVSTRUCTURE_HOSTS"), reflect.TypeOf(""))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a,b"), v)
+}
+
+func TestStringToIPHookFunc(t *testing.T) {
+	f := StringToIPHookFunc()
+
+	v, err := f([]byte("127.0.0.1"), reflect.TypeOf(net.IP{}))
+	assert.NoError(t, err)
+	assert.Equal(t, net.ParseIP("127.0.0.1"), v)
+
+	_, err = f([]byte("localhost"), reflect.TypeOf(net.IP{}))
+	assert.Error(t, err)
+
+	v, err = f([]byte("localhost"), reflect.TypeOf(""))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("localhost"), v)
+}
+
+func TestTransdecodeDecodeHook(t *testing.T) {
+	os.Setenv("KVSTRUCTURE_PORT", "8080")
+	defer os.Unsetenv("KVSTRUCTURE_PORT")
+
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/port", []byte("${KVSTRUCTURE_PORT}"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/ports", []byte("80,443"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/tags", []byte("a,b"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/ip", []byte("::1"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/timeout", []byte("1m"), nil))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithDecodeHook(ExpandEnvHookFunc()),
+		TransdecoderWithDecodeHook(StringToSliceHookFunc(",")),
+		TransdecoderWithDecodeHook(StringToIPHookFunc()),
+		TransdecoderWithDecodeHook(StringToTimeDurationHookFunc()),
+	)
+	assert.NoError(t, err)
+
+	tt := new(struct {
+		Port    int
+		Ports   []int
+		Tags    [2]string
+		IP      net.IP
+		Timeout time.Duration
+	})
+
+	err = td.Transdecode("foo", tt)
+	assert.NoError(t, err)
+	assert.Equal(t, 8080, tt.Port)
+	assert.Equal(t, []int{80, 443}, tt.Ports)
+	assert.Equal(t, [2]string{"a", "b"}, tt.Tags)
+	assert.Equal(t, net.ParseIP("::1"), tt.IP)
+	assert.Equal(t, time.Minute, tt.Timeout)
+}
@@ -0,0 +1,450 @@
+// Package memstore implements an in-memory store.Store.
+//
+// It is meant to be used in tests, where it allows to transcode
+// and transdecode data without a running KV.
+package memstore
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/libkv/store"
+)
+
+var (
+	// ErrNotLocked is returned when a lock is released that is not held
+	ErrNotLocked = errors.New("memstore: lock is not held")
+)
+
+// Memstore is an in-memory store. The keys are stored as is,
+// and a directory contains all the keys below "directory/".
+type Memstore struct {
+	// Endpoints passed to New
+	Endpoints []string
+
+	// Options passed to New
+	Options *store.Config
+
+	mu       sync.RWMutex
+	index    uint64
+	data     map[string]*store.KVPair
+	locks    map[string]chan struct{}
+	watchers map[*watcher]struct{}
+	done     chan struct{}
+	closed   bool
+}
+
+// watcher is notified about every change in the store
+type watcher struct {
+	notify chan struct{}
+}
+
+// New creates a Memstore
+func New(endpoints []string, options *store.Config) (store.Store, error) {
+	m := &Memstore{
+		Endpoints: endpoints,
+		Options:   options,
+		data:      make(map[string]*store.KVPair),
+		locks:     make(map[string]chan struct{}),
+		watchers:  make(map[*watcher]struct{}),
+		done:      make(chan struct{}),
+	}
+
+	return m, nil
+}
+
+// Put a value at the specified key
+func (m *Memstore) Put(key string, value []byte, opts *store.WriteOptions) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.put(key, value)
+
+	return nil
+}
+
+// Get a value given its key
+func (m *Memstore) Get(key string) (*store.KVPair, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	kvPair, ok := m.data[key]
+	if !ok {
+		return nil, store.ErrKeyNotFound
+	}
+
+	return copyKVPair(kvPair), nil
+}
+
+// Delete the value at the specified key
+func (m *Memstore) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.data[key]; !ok {
+		return store.ErrKeyNotFound
+	}
+
+	m.delete(key)
+
+	return nil
+}
+
+// Exists verifies if a key exists in the store
+func (m *Memstore) Exists(key string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.data[key]
+
+	return ok, nil
+}
+
+// Watch for changes on a key. The current value is sent first,
+// if the key exists. Deletions of the key are not sent.
+func (m *Memstore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	w := m.watch()
+	ch := make(chan *store.KVPair)
+
+	go func() {
+		defer close(ch)
+		defer m.unwatch(w)
+
+		var last uint64
+
+		for {
+			kvPair, err := m.Get(key)
+			if err == nil && kvPair.LastIndex != last {
+				last = kvPair.LastIndex
+
+				select {
+				case ch <- kvPair:
+				case <-stopCh:
+					return
+				case <-m.done:
+					return
+				}
+			}
+
+			select {
+			case <-w.notify:
+			case <-stopCh:
+				return
+			case <-m.done:
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// WatchTree watches for changes on child nodes under a given directory.
+// The current content of the directory is sent first.
+func (m *Memstore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	w := m.watch()
+	ch := make(chan []*store.KVPair)
+
+	go func() {
+		defer close(ch)
+		defer m.unwatch(w)
+
+		var last []*store.KVPair
+
+		for {
+			m.mu.RLock()
+			kvPairs := m.list(directory)
+			m.mu.RUnlock()
+
+			if last == nil || !sameKVPairs(last, kvPairs) {
+				last = kvPairs
+
+				select {
+				case ch <- kvPairs:
+				case <-stopCh:
+					return
+				case <-m.done:
+					return
+				}
+			}
+
+			select {
+			case <-w.notify:
+			case <-stopCh:
+				return
+			case <-m.done:
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// NewLock creates a lock for a given key. The value of the lock
+// is put at the key while the lock is held.
+func (m *Memstore) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sem, ok := m.locks[key]
+	if !ok {
+		sem = make(chan struct{}, 1)
+		m.locks[key] = sem
+	}
+
+	l := &Lock{
+		m:   m,
+		key: key,
+		sem: sem,
+	}
+
+	if options != nil {
+		l.value = options.Value
+	}
+
+	return l, nil
+}
+
+// List the content of a given directory
+func (m *Memstore) List(directory string) ([]*store.KVPair, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	kvPairs := m.list(directory)
+	if len(kvPairs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+
+	return kvPairs, nil
+}
+
+// DeleteTree deletes the directory and all the keys below it
+func (m *Memstore) DeleteTree(directory string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dir := strings.TrimSuffix(directory, "/")
+	for key := range m.data {
+		if key == dir || strings.HasPrefix(key, dir+"/") {
+			m.delete(key)
+		}
+	}
+
+	return nil
+}
+
+// AtomicPut puts the value if the key has not been modified since
+// the previous pair was read. If previous is nil, the key must not exist.
+func (m *Memstore) AtomicPut(key string, value []byte, previous *store.KVPair, opts *store.WriteOptions) (bool, *store.KVPair, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kvPair, ok := m.data[key]
+
+	switch {
+	case previous == nil && ok:
+		return false, nil, store.ErrKeyExists
+	case previous != nil && !ok:
+		return false, nil, store.ErrKeyNotFound
+	case previous != nil && kvPair.LastIndex != previous.LastIndex:
+		return false, nil, store.ErrKeyModified
+	}
+
+	return true, copyKVPair(m.put(key, value)), nil
+}
+
+// AtomicDelete deletes the key if it has not been modified since
+// the previous pair was read
+func (m *Memstore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	if previous == nil {
+		return false, store.ErrPreviousNotSpecified
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kvPair, ok := m.data[key]
+	if !ok {
+		return false, store.ErrKeyNotFound
+	}
+
+	if kvPair.LastIndex != previous.LastIndex {
+		return false, store.ErrKeyModified
+	}
+
+	m.delete(key)
+
+	return true, nil
+}
+
+// Close the store and stops all watches
+func (m *Memstore) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return
+	}
+
+	m.closed = true
+	close(m.done)
+}
+
+// put sets the value and notifies the watchers, the lock has to be held
+func (m *Memstore) put(key string, value []byte) *store.KVPair {
+	m.index++
+
+	v := make([]byte, len(value))
+	copy(v, value)
+
+	kvPair := &store.KVPair{
+		Key:       key,
+		Value:     v,
+		LastIndex: m.index,
+	}
+	m.data[key] = kvPair
+
+	m.notify()
+
+	return kvPair
+}
+
+// delete removes the key and notifies the watchers, the lock has to be held
+func (m *Memstore) delete(key string) {
+	m.index++
+
+	delete(m.data, key)
+
+	m.notify()
+}
+
+// list returns copies of all pairs below the directory sorted by key,
+// the lock has to be held
+func (m *Memstore) list(directory string) []*store.KVPair {
+	dir := strings.TrimSuffix(directory, "/") + "/"
+
+	kvPairs := make([]*store.KVPair, 0)
+	for key, kvPair := range m.data {
+		if strings.HasPrefix(key, dir) {
+			kvPairs = append(kvPairs, copyKVPair(kvPair))
+		}
+	}
+
+	sort.Slice(kvPairs, func(i, j int) bool {
+		return kvPairs[i].Key < kvPairs[j].Key
+	})
+
+	return kvPairs
+}
+
+// notify signals a change to all watchers, the lock has to be held
+func (m *Memstore) notify() {
+	for w := range m.watchers {
+		select {
+		case w.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// watch registers a new watcher
+func (m *Memstore) watch() *watcher {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w := &watcher{notify: make(chan struct{}, 1)}
+	m.watchers[w] = struct{}{}
+
+	return w
+}
+
+// unwatch removes the watcher
+func (m *Memstore) unwatch(w *watcher) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.watchers, w)
+}
+
+// copyKVPair returns a copy of the pair that can be handed out
+func copyKVPair(kvPair *store.KVPair) *store.KVPair {
+	v := make([]byte, len(kvPair.Value))
+	copy(v, kvPair.Value)
+
+	return &store.KVPair{
+		Key:       kvPair.Key,
+		Value:     v,
+		LastIndex: kvPair.LastIndex,
+	}
+}
+
+// sameKVPairs reports whether the sorted pairs have the same keys and indexes
+func sameKVPairs(a, b []*store.KVPair) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].Key != b[i].Key || a[i].LastIndex != b[i].LastIndex {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Lock is the memstore implementation of Locker
+type Lock struct {
+	m     *Memstore
+	key   string
+	value []byte
+	sem   chan struct{}
+
+	mu   sync.Mutex
+	lost chan struct{}
+}
+
+// Lock blocks until the lock is acquired or the stopChan is closed.
+// The returned channel is closed when the lock is released.
+func (l *Lock) Lock(stopChan chan struct{}) (<-chan struct{}, error) {
+	select {
+	case l.sem <- struct{}{}:
+	case <-stopChan:
+		return nil, store.ErrCannotLock
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lost = make(chan struct{})
+
+	if err := l.m.Put(l.key, l.value, nil); err != nil {
+		return nil, err
+	}
+
+	return l.lost, nil
+}
+
+// Unlock releases the lock and deletes its key
+func (l *Lock) Unlock() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.lost == nil {
+		return ErrNotLocked
+	}
+
+	if err := l.m.Delete(l.key); err != nil && err != store.ErrKeyNotFound {
+		return err
+	}
+
+	close(l.lost)
+	l.lost = nil
+
+	<-l.sem
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package memstore_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/andersnormal/kvstructure/memstore"
+
+	"github.com/docker/libkv/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPutGet(t *testing.T) {
+	kv, _ := New(nil, nil)
+
+	err := kv.Put("foo/bar", []byte("baz"), nil)
+	assert.NoError(t, err)
+
+	kvPair, err := kv.Get("foo/bar")
+	assert.NoError(t, err)
+	assert.Equal(t, "foo/bar", kvPair.Key)
+	assert.Equal(t, []byte("baz"), kvPair.Value)
+	assert.NotZero(t, kvPair.LastIndex)
+
+	_, err = kv.Get("foo/baz")
+	assert.Equal(t, store.ErrKeyNotFound, err)
+}
+
+func TestDeleteExists(t *testing.T) {
+	kv, _ := New(nil, nil)
+
+	err := kv.Put("foo", []byte("bar"), nil)
+	assert.NoError(t, err)
+
+	ok, err := kv.Exists("foo")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	err = kv.Delete("foo")
+	assert.NoError(t, err)
+
+	ok, err = kv.Exists("foo")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	err = kv.Delete("foo")
+	assert.Equal(t, store.ErrKeyNotFound, err)
+}
+
+func TestListDeleteTree(t *testing.T) {
+	kv, _ := New(nil, nil)
+
+	for _, key := range []string{"foo", "foo/b", "foo/a/c", "foobar", "bar"} {
+		assert.NoError(t, kv.Put(key, []byte(key), nil))
+	}
+
+	kvPairs, err := kv.List("foo")
+	assert.NoError(t, err)
+	if assert.Len(t, kvPairs, 2) {
+		assert.Equal(t, "foo/a/c", kvPairs[0].Key)
+		assert.Equal(t, "foo/b", kvPairs[1].Key)
+	}
+
+	_, err = kv.List("baz")
+	assert.Equal(t, store.ErrKeyNotFound, err)
+
+	err = kv.DeleteTree("foo")
+	assert.NoError(t, err)
+
+	for key, exists := range map[string]bool{"foo": false, "foo/b": false, "foo/a/c": false, "foobar": true, "bar": true} {
+		ok, _ := kv.Exists(key)
+		assert.Equal(t, exists, ok, key)
+	}
+}
+
+func TestAtomicPut(t *testing.T) {
+	kv, _ := New(nil, nil)
+
+	ok, kvPair, err := kv.AtomicPut("foo", []byte("bar"), nil, nil)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	_, _, err = kv.AtomicPut("foo", []byte("bar"), nil, nil)
+	assert.Equal(t, store.ErrKeyExists, err)
+
+	ok, next, err := kv.AtomicPut("foo", []byte("baz"), kvPair, nil)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, next.LastIndex > kvPair.LastIndex)
+
+	_, _, err = kv.AtomicPut("foo", []byte("qux"), kvPair, nil)
+	assert.Equal(t, store.ErrKeyModified, err)
+
+	_, _, err = kv.AtomicPut("bar", []byte("qux"), kvPair, nil)
+	assert.Equal(t, store.ErrKeyNotFound, err)
+}
+
+func TestAtomicDelete(t *testing.T) {
+	kv, _ := New(nil, nil)
+
+	_, kvPair, err := kv.AtomicPut("foo", []byte("bar"), nil, nil)
+	assert.NoError(t, err)
+
+	_, err = kv.AtomicDelete("foo", nil)
+	assert.Equal(t, store.ErrPreviousNotSpecified, err)
+
+	assert.NoError(t, kv.Put("foo", []byte("baz"), nil))
+
+	_, err = kv.AtomicDelete("foo", kvPair)
+	assert.Equal(t, store.ErrKeyModified, err)
+
+	kvPair, err = kv.Get("foo")
+	assert.NoError(t, err)
+
+	ok, err := kv.AtomicDelete("foo", kvPair)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	_, err = kv.AtomicDelete("foo", kvPair)
+	assert.Equal(t, store.ErrKeyNotFound, err)
+}
+
+func TestWatch(t *testing.T) {
+	kv, _ := New(nil, nil)
+	assert.NoError(t, kv.Put("foo", []byte("bar"), nil))
+
+	stopCh := make(chan struct{})
+	ch, err := kv.Watch("foo", stopCh)
+	assert.NoError(t, err)
+
+	kvPair := <-ch
+	assert.Equal(t, []byte("bar"), kvPair.Value)
+
+	assert.NoError(t, kv.Put("foo", []byte("baz"), nil))
+
+	kvPair = <-ch
+	assert.Equal(t, []byte("baz"), kvPair.Value)
+
+	close(stopCh)
+
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func TestWatchTree(t *testing.T) {
+	kv, _ := New(nil, nil)
+	assert.NoError(t, kv.Put("foo/a", []byte("a"), nil))
+
+	stopCh := make(chan struct{})
+	ch, err := kv.WatchTree("foo", stopCh)
+	assert.NoError(t, err)
+
+	kvPairs := <-ch
+	assert.Len(t, kvPairs, 1)
+
+	assert.NoError(t, kv.Put("foo/b", []byte("b"), nil))
+
+	kvPairs = <-ch
+	assert.Len(t, kvPairs, 2)
+
+	assert.NoError(t, kv.DeleteTree("foo"))
+
+	kvPairs = <-ch
+	assert.Len(t, kvPairs, 0)
+
+	kv.Close()
+
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func TestLock(t *testing.T) {
+	kv, _ := New(nil, nil)
+
+	l1, err := kv.NewLock("lock", &store.LockOptions{Value: []byte("one")})
+	assert.NoError(t, err)
+
+	l2, err := kv.NewLock("lock", &store.LockOptions{Value: []byte("two")})
+	assert.NoError(t, err)
+
+	lost, err := l1.Lock(nil)
+	assert.NoError(t, err)
+
+	kvPair, err := kv.Get("lock")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("one"), kvPair.Value)
+
+	stopCh := make(chan struct{})
+	time.AfterFunc(10*time.Millisecond, func() { close(stopCh) })
+
+	_, err = l2.Lock(stopCh)
+	assert.Equal(t, store.ErrCannotLock, err)
+
+	assert.NoError(t, l1.Unlock())
+	_, ok := <-lost
+	assert.False(t, ok)
+
+	_, err = l2.Lock(nil)
+	assert.NoError(t, err)
+	assert.NoError(t, l2.Unlock())
+
+	assert.Equal(t, ErrNotLocked, l2.Unlock())
+}
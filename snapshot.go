@@ -0,0 +1,71 @@
+package kvstructure
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/docker/libkv/store"
+)
+
+// snapshot is a point-in-time view of all the keys below a root key.
+// The keys are kept in order, so that the keys below any key
+// can be found without a roundtrip to the kv.
+type snapshot struct {
+	root    string
+	kvPairs []*store.KVPair
+}
+
+// newSnapshot creates a snapshot from the pairs listed below the root
+func newSnapshot(root string, kvPairs []*store.KVPair) *snapshot {
+	s := &snapshot{
+		root:    trailingSlash(root),
+		kvPairs: make([]*store.KVPair, 0, len(kvPairs)),
+	}
+
+	for _, kvPair := range kvPairs {
+		if strings.HasPrefix(kvPair.Key, s.root) {
+			s.kvPairs = append(s.kvPairs, kvPair)
+		}
+	}
+
+	sort.Slice(s.kvPairs, func(i, j int) bool {
+		return s.kvPairs[i].Key < s.kvPairs[j].Key
+	})
+
+	return s
+}
+
+// covers reports whether the key is below the root, and thus
+// is resolved from the snapshot
+func (s *snapshot) covers(key string) bool {
+	return strings.HasPrefix(key, s.root)
+}
+
+// get returns the pair of the key
+func (s *snapshot) get(key string) (*store.KVPair, error) {
+	i := sort.Search(len(s.kvPairs), func(i int) bool {
+		return s.kvPairs[i].Key >= key
+	})
+
+	if i < len(s.kvPairs) && s.kvPairs[i].Key == key {
+		return s.kvPairs[i], nil
+	}
+
+	return nil, store.ErrKeyNotFound
+}
+
+// list returns the pairs below the key
+func (s *snapshot) list(key string) []*store.KVPair {
+	key = trailingSlash(key)
+
+	i := sort.Search(len(s.kvPairs), func(i int) bool {
+		return s.kvPairs[i].Key >= key
+	})
+
+	j := i
+	for j < len(s.kvPairs) && strings.HasPrefix(s.kvPairs[j].Key, key) {
+		j++
+	}
+
+	return s.kvPairs[i:j]
+}
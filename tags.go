@@ -0,0 +1,73 @@
+package kvstructure
+
+import (
+	"strings"
+)
+
+// tagOptions are the comma-separated options following the name in a struct field's tag
+type tagOptions string
+
+// parseTag splits a struct field's tag into its name and options
+func parseTag(tag string) (string, tagOptions) {
+	if idx := strings.Index(tag, ","); idx != -1 {
+		return tag[:idx], tagOptions(tag[idx+1:])
+	}
+
+	return tag, tagOptions("")
+}
+
+// Has reports whether the options contain the given option
+func (o tagOptions) Has(opt string) bool {
+	if len(o) == 0 {
+		return false
+	}
+
+	for _, s := range strings.Split(string(o), ",") {
+		// the default is the last option, and it may contain commas
+		if strings.HasPrefix(s, "default=") {
+			return false
+		}
+
+		if s == opt {
+			return true
+		}
+	}
+
+	return false
+}
+
+// defaultValue returns the value of the default option, which is
+// everything after "default=" as it is the last option
+func (o tagOptions) defaultValue() (string, bool) {
+	s := string(o)
+
+	for {
+		if strings.HasPrefix(s, "default=") {
+			return strings.TrimPrefix(s, "default="), true
+		}
+
+		idx := strings.Index(s, ",")
+		if idx == -1 {
+			return "", false
+		}
+		s = s[idx+1:]
+	}
+}
+
+// encoding returns the encoding of the value, or the empty string
+// if the value is not encoded
+func (o tagOptions) encoding() string {
+	for _, enc := range encodings {
+		if o.Has(enc) {
+			return enc
+		}
+	}
+
+	return ""
+}
+
+// squash reports whether the fields of an embedded struct should be
+// treated as if they were fields of the parent struct
+func (o tagOptions) squash() bool {
+	return o.Has("squash") || o.Has("inline")
+}
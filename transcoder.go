@@ -2,19 +2,26 @@ package kvstructure
 
 import (
 	"context"
-	"encoding/json"
+	"encoding"
 	"errors"
 	"fmt"
+	"path"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/docker/libkv/store"
 	"golang.org/x/sync/errgroup"
 )
 
 const (
-	defaultTagName = "kvstructure"
+	defaultTagName        = "kvstructure"
+	defaultFloatFormat    = 'g'
+	defaultFloatPrecision = -1
+	defaultTimeLayout     = time.RFC3339Nano
 )
 
 // Transcode takes an initialized interface and puts the data in a kv
@@ -52,9 +59,11 @@ func Transcode(name string, s interface{}, prefix string, kv store.Store) error
 //  }
 func NewTranscoder(opts ...TranscoderOpt) (Transcoder, error) {
 	options := new(TranscoderOpts)
+	options.FloatPrecision = defaultFloatPrecision
 
 	t := new(transcoder)
 	t.opts = options
+	t.mu = new(sync.Mutex)
 
 	// configure transcoder
 	configureTranscoder(t, opts...)
@@ -76,8 +85,78 @@ func TranscoderWithKV(kv store.Store) func(o *TranscoderOpts) {
 	}
 }
 
+// TranscoderWithMetadata tracks the written keys, and the keys
+// that have been found but not written, in the metadata
+func TranscoderWithMetadata(md *Metadata) func(o *TranscoderOpts) {
+	return func(o *TranscoderOpts) {
+		o.Metadata = md
+	}
+}
+
+// TranscoderWithFloatFormat sets the format and precision of floats.
+// See strconv.FormatFloat for the meaning of the arguments.
+func TranscoderWithFloatFormat(format byte, prec int) func(o *TranscoderOpts) {
+	return func(o *TranscoderOpts) {
+		o.FloatFormat = format
+		o.FloatPrecision = prec
+	}
+}
+
+// TranscoderWithJSONTags encodes fields with a json tag as JSON
+func TranscoderWithJSONTags() func(o *TranscoderOpts) {
+	return func(o *TranscoderOpts) {
+		o.JSONTags = true
+	}
+}
+
+// TranscoderWithCodec uses the codec to encode the values of the type
+func TranscoderWithCodec(typ reflect.Type, c Codec) func(o *TranscoderOpts) {
+	return func(o *TranscoderOpts) {
+		if o.Codecs == nil {
+			o.Codecs = make(map[reflect.Type]Codec)
+		}
+		o.Codecs[typ] = c
+	}
+}
+
+// TranscoderWithTimeLayout sets the layout that is used to write a time.Time
+func TranscoderWithTimeLayout(layout string) func(o *TranscoderOpts) {
+	return func(o *TranscoderOpts) {
+		o.TimeLayout = layout
+	}
+}
+
+// TranscoderWithCollectErrors returns all errors instead of the first error
+func TranscoderWithCollectErrors() func(o *TranscoderOpts) {
+	return func(o *TranscoderOpts) {
+		o.CollectErrors = true
+	}
+}
+
+// TranscoderWithDeleteNil deletes the subtree of nil pointers and interfaces
+func TranscoderWithDeleteNil() func(o *TranscoderOpts) {
+	return func(o *TranscoderOpts) {
+		o.DeleteNil = true
+	}
+}
+
+// TranscoderWithPrune deletes all keys below the name which have not been written,
+// except for the keys matching the patterns to keep
+func TranscoderWithPrune(keep ...string) func(o *TranscoderOpts) {
+	return func(o *TranscoderOpts) {
+		o.Prune = true
+		o.Keep = append(o.Keep, keep...)
+	}
+}
+
 // Transcode is transcoding a given raw value interface to data in a kv store
 func (t *transcoder) Transcode(name string, s interface{}) error {
+	return t.TranscodeContext(context.Background(), name, s)
+}
+
+// TranscodeContext is transcoding a given raw value interface to data in a kv store.
+// No more data is written to the kv once the context is done.
+func (t *transcoder) TranscodeContext(ctx context.Context, name string, s interface{}) error {
 	val := reflect.ValueOf(s)
 	if val.Kind() != reflect.Ptr {
 		return errors.New("kvstructure: interface must be a pointer")
@@ -88,93 +167,512 @@ func (t *transcoder) Transcode(name string, s interface{}) error {
 		return errors.New("kvstructure: interface must be addressable (a pointer)")
 	}
 
-	return t.transcode(name, reflect.ValueOf(s).Elem())
+	tc := t.fork()
+	if err := tc.transcode(ctx, name, reflect.ValueOf(s).Elem()); err != nil {
+		return err
+	}
+
+	if err := tc.prune(ctx, name); err != nil {
+		return err
+	}
+
+	return tc.trackUnused(ctx, name)
+}
+
+// TranscodeDiff is transcoding a given raw value interface to data in a kv store,
+// but it only writes the keys of which the value has changed, and deletes only
+// the keys that are not written anymore. It returns the changes it applied.
+func (t *transcoder) TranscodeDiff(ctx context.Context, name string, s interface{}) (*ChangeSet, error) {
+	val := reflect.ValueOf(s)
+	if val.Kind() != reflect.Ptr {
+		return nil, errors.New("kvstructure: interface must be a pointer")
+	}
+
+	val = val.Elem()
+	if !val.CanAddr() {
+		return nil, errors.New("kvstructure: interface must be addressable (a pointer)")
+	}
+
+	tc := t.fork()
+	tc.diff = newDiff()
+
+	// the existing keys are listed before anything is written
+	existing, err := tc.existing(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tc.transcode(ctx, name, val); err != nil {
+		return nil, err
+	}
+
+	cs, err := tc.apply(ctx, existing)
+	if err != nil {
+		return cs, err
+	}
+
+	return cs, tc.trackUnused(ctx, name)
+}
+
+// existing returns the values of the key and all the keys below it
+func (t *transcoder) existing(ctx context.Context, name string) (map[string][]byte, error) {
+	if err := contextError(ctx, t.fullKey(name)); err != nil {
+		return nil, err
+	}
+
+	kvPairs, err := t.opts.KV.List(t.fullKey(name))
+	if err != nil && !errors.Is(err, store.ErrKeyNotFound) {
+		return nil, newError(t.fullKey(name), nil, nil, err)
+	}
+
+	kvPair, err := t.opts.KV.Get(t.fullKey(name))
+	if err != nil && !errors.Is(err, store.ErrKeyNotFound) {
+		return nil, newError(t.fullKey(name), nil, nil, err)
+	}
+
+	if kvPair != nil {
+		kvPairs = append(kvPairs, kvPair)
+	}
+
+	existing := make(map[string][]byte, len(kvPairs))
+	for _, kvPair := range kvPairs {
+		if inTree(kvPair.Key, t.fullKey(name)) {
+			existing[strings.TrimPrefix(kvPair.Key, trailingSlash(t.opts.Prefix))] = kvPair.Value
+		}
+	}
+
+	return existing, nil
+}
+
+// apply writes the changed keys and deletes the keys which are not written anymore
+func (t *transcoder) apply(ctx context.Context, existing map[string][]byte) (*ChangeSet, error) {
+	cs := &ChangeSet{
+		Puts:    make([]string, 0),
+		Deletes: make([]string, 0),
+	}
+
+	puts, deletes := t.diff.changes(existing, t.isPruned)
+
+	for _, key := range puts {
+		if err := contextError(ctx, t.fullKey(key)); err != nil {
+			return cs, err
+		}
+
+		if err := t.opts.KV.Put(t.fullKey(key), t.diff.puts[key], nil); err != nil {
+			return cs, newError(t.fullKey(key), nil, t.diff.puts[key], err)
+		}
+		cs.Puts = append(cs.Puts, key)
+	}
+
+	for _, key := range deletes {
+		if err := contextError(ctx, t.fullKey(key)); err != nil {
+			return cs, err
+		}
+
+		if err := t.opts.KV.Delete(t.fullKey(key)); err != nil && !errors.Is(err, store.ErrKeyNotFound) {
+			return cs, newError(t.fullKey(key), nil, nil, err)
+		}
+		cs.Deletes = append(cs.Deletes, key)
+	}
+
+	return cs, nil
+}
+
+// prune deletes the keys below the name that have not been written in this run
+func (t *transcoder) prune(ctx context.Context, name string) error {
+	if !t.opts.Prune {
+		return nil
+	}
+
+	if err := contextError(ctx, t.fullKey(name)); err != nil {
+		return err
+	}
+
+	kvPairs, err := t.opts.KV.List(t.fullKey(name))
+	if err != nil && !errors.Is(err, store.ErrKeyNotFound) {
+		return newError(t.fullKey(name), nil, nil, err)
+	}
+
+	for _, kvPair := range kvPairs {
+		if !inTree(kvPair.Key, t.fullKey(name)) {
+			continue
+		}
+
+		key := strings.TrimPrefix(kvPair.Key, trailingSlash(t.opts.Prefix))
+		if !t.isPruned(key) {
+			continue
+		}
+
+		if err := contextError(ctx, kvPair.Key); err != nil {
+			return err
+		}
+
+		if err := t.opts.KV.Delete(kvPair.Key); err != nil && !errors.Is(err, store.ErrKeyNotFound) {
+			return newError(kvPair.Key, nil, nil, err)
+		}
+	}
+
+	return nil
+}
+
+// isPruned reports whether the key is deleted in prune mode, because it has not
+// been written in this run and does not match any of the patterns to keep
+func (t *transcoder) isPruned(key string) bool {
+	if !t.opts.Prune || t.keys.has(key) {
+		return false
+	}
+
+	for _, pattern := range t.opts.Keep {
+		for k := key; k != "." && k != "/"; k = path.Dir(k) {
+			if ok, _ := path.Match(pattern, k); ok {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// fork returns a copy of the transcoder for a single run
+func (t *transcoder) fork() *transcoder {
+	return &transcoder{
+		opts: t.opts,
+		mu:   t.mu,
+		keys: newKeySet(),
+	}
+}
+
+// trackUnused adds the keys below the name that have not been written
+// in this run to the metadata
+func (t *transcoder) trackUnused(ctx context.Context, name string) error {
+	if t.opts.Metadata == nil {
+		return nil
+	}
+
+	if err := contextError(ctx, t.fullKey(name)); err != nil {
+		return err
+	}
+
+	kvPairs, err := t.opts.KV.List(t.fullKey(name))
+	if err != nil && !errors.Is(err, store.ErrKeyNotFound) {
+		return newError(t.fullKey(name), nil, nil, err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, kvPair := range kvPairs {
+		if !inTree(kvPair.Key, t.fullKey(name)) {
+			continue
+		}
+
+		key := strings.TrimPrefix(kvPair.Key, trailingSlash(t.opts.Prefix))
+		if !t.keys.has(key) {
+			t.opts.Metadata.Unused = append(t.opts.Metadata.Unused, key)
+		}
+	}
+
+	return nil
 }
 
 // transcode is doing the heavy lifting in the background
-func (t *transcoder) transcode(name string, val reflect.Value) error {
+func (t *transcoder) transcode(ctx context.Context, name string, val reflect.Value) error {
+	switch val.Kind() {
+	case reflect.Ptr:
+		if val.IsNil() {
+			return t.transcodeNil(ctx, name)
+		}
+
+		return t.transcode(ctx, name, val.Elem())
+	case reflect.Interface:
+		if val.IsNil() {
+			return t.transcodeNil(ctx, name)
+		}
+
+		// the value of an interface is not addressable, so we work on a copy
+		v := reflect.New(val.Elem().Type()).Elem()
+		v.Set(val.Elem())
+
+		return t.transcode(ctx, name, v)
+	}
+
+	// values of a type with a codec are written as a single key
+	if c, ok := t.opts.Codecs[val.Type()]; ok {
+		return withType(t.transcodeCodec(ctx, name, val, c), val.Type())
+	}
+
+	// values that write their own keys are not reflected
+	if marshalsKV(val.Type()) {
+		return withType(t.transcodeKV(ctx, name, val), val.Type())
+	}
+
+	// values with a text representation are written as a single key
+	if isText(val.Type()) {
+		return withType(t.transcodeText(ctx, name, val), val.Type())
+	}
+
 	var err error
-	valKind := getKind(reflect.Indirect(val))
+	valKind := getKind(val)
 	switch valKind {
 	case reflect.String:
-		err = t.transcodeString(name, val)
+		err = t.transcodeString(ctx, name, val)
 	case reflect.Bool:
-		err = t.transcodeBool(name, val)
+		err = t.transcodeBool(ctx, name, val)
 	case reflect.Int:
-		err = t.transcodeInt(name, val)
+		err = t.transcodeInt(ctx, name, val)
 	case reflect.Uint:
-		err = t.transcodeUint(name, val)
+		err = t.transcodeUint(ctx, name, val)
 	case reflect.Float32:
-		err = t.transcodeFloat(name, val)
+		err = t.transcodeFloat(ctx, name, val)
 	case reflect.Struct:
-		err = t.transcodeStruct(name, val)
+		err = t.transcodeStruct(ctx, name, val)
 	case reflect.Slice:
 		// silent do nothing
-		err = t.transcodeSlice(name, val)
+		err = t.transcodeSlice(ctx, name, val)
+	case reflect.Array:
+		err = t.transcodeSlice(ctx, name, val)
+	case reflect.Map:
+		err = t.transcodeMap(ctx, name, val)
 	default:
 		// we have to work on here for value to pointed to
-		return fmt.Errorf("kvstructure: unsupported type %s", valKind)
+		return newError(t.fullKey(name), val.Type(), nil, fmt.Errorf("unsupported type %s", valKind))
 	}
 
 	// should be nil
-	return err
+	return withType(err, val.Type())
+}
+
+// transcodeNil deletes or skips the subtree of a nil pointer or interface
+func (t *transcoder) transcodeNil(ctx context.Context, name string) error {
+	if !t.opts.DeleteNil {
+		return nil
+	}
+
+	if err := t.deleteTree(ctx, name); err != nil && !errors.Is(err, store.ErrKeyNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// transcodeCodec writes the value that is encoded by the codec
+func (t *transcoder) transcodeCodec(ctx context.Context, name string, val reflect.Value, c Codec) error {
+	b, err := c.Encode(val.Interface())
+	if err != nil {
+		return newError(t.fullKey(name), val.Type(), nil, err)
+	}
+
+	return t.putKVPair(ctx, name, b)
+}
+
+// transcodeKV writes the keys of a KVMarshaler below the name
+func (t *transcoder) transcodeKV(ctx context.Context, name string, val reflect.Value) error {
+	m, ok := val.Interface().(KVMarshaler)
+	if !ok {
+		// the method has a pointer receiver, so we work on an addressable copy
+		v := reflect.New(val.Type())
+		v.Elem().Set(val)
+		m = v.Interface().(KVMarshaler)
+	}
+
+	kvs, err := m.MarshalKV()
+	if err != nil {
+		return newError(t.fullKey(name), val.Type(), nil, err)
+	}
+
+	keys := make([]string, 0, len(kvs))
+	for k := range kvs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	errs := new(errorList)
+
+	for _, k := range keys {
+		key := name
+		if k != "" {
+			key = strings.Join([]string{name, strings.Trim(k, "/")}, "/")
+		}
+
+		if err := t.putKVPair(ctx, key, kvs[k]); err != nil {
+			if !t.opts.CollectErrors {
+				return err
+			}
+			errs.add(err)
+		}
+	}
+
+	return errs.err()
+}
+
+// transcodeText writes durations, times and text marshalers as a single key
+func (t *transcoder) transcodeText(ctx context.Context, name string, val reflect.Value) error {
+	switch val.Type() {
+	case durationType:
+		return t.putKVPair(ctx, name, []byte(time.Duration(val.Int()).String()))
+	case timeType:
+		return t.putKVPair(ctx, name, []byte(val.Interface().(time.Time).Format(t.opts.TimeLayout)))
+	}
+
+	m, ok := val.Interface().(encoding.TextMarshaler)
+	if !ok {
+		// the method has a pointer receiver, so we work on an addressable copy
+		v := reflect.New(val.Type())
+		v.Elem().Set(val)
+		m = v.Interface().(encoding.TextMarshaler)
+	}
+
+	b, err := m.MarshalText()
+	if err != nil {
+		return newError(t.fullKey(name), val.Type(), nil, err)
+	}
+
+	return t.putKVPair(ctx, name, b)
 }
 
 // transdecodeString
-func (t *transcoder) transcodeString(name string, val reflect.Value) error {
-	return t.putKVPair(name, []byte(val.String()))
+func (t *transcoder) transcodeString(ctx context.Context, name string, val reflect.Value) error {
+	return t.putKVPair(ctx, name, []byte(val.String()))
 }
 
 // transcodeBool
-func (t *transcoder) transcodeBool(name string, val reflect.Value) error {
-	return t.putKVPair(name, []byte(fmt.Sprint(val)))
+func (t *transcoder) transcodeBool(ctx context.Context, name string, val reflect.Value) error {
+	return t.putKVPair(ctx, name, []byte(fmt.Sprint(val)))
 }
 
 // transcodeInt
-func (t *transcoder) transcodeInt(name string, val reflect.Value) error {
-	return t.putKVPair(name, []byte(fmt.Sprint(val)))
+func (t *transcoder) transcodeInt(ctx context.Context, name string, val reflect.Value) error {
+	return t.putKVPair(ctx, name, []byte(fmt.Sprint(val)))
 }
 
 // transdecodeUint
-func (t *transcoder) transcodeUint(name string, val reflect.Value) error {
-	return t.putKVPair(name, []byte(fmt.Sprint(val)))
+func (t *transcoder) transcodeUint(ctx context.Context, name string, val reflect.Value) error {
+	return t.putKVPair(ctx, name, []byte(fmt.Sprint(val)))
 }
 
-// transdecodeFloat
-func (t *transcoder) transcodeFloat(name string, val reflect.Value) error {
-	return nil
+// transcodeFloat writes the float with the precision of its type,
+// which is the shortest representation that reads back exactly by default.
+// NaN and infinities are written as "NaN", "+Inf" and "-Inf".
+func (t *transcoder) transcodeFloat(ctx context.Context, name string, val reflect.Value) error {
+	f := strconv.FormatFloat(val.Float(), t.opts.FloatFormat, t.opts.FloatPrecision, val.Type().Bits())
+
+	return t.putKVPair(ctx, name, []byte(f))
 }
 
-// transcodeSlice
-func (t *transcoder) transcodeSlice(name string, val reflect.Value) error {
+// transcodeSlice writes the elements of a slice or an array by their index
+func (t *transcoder) transcodeSlice(ctx context.Context, name string, val reflect.Value) error {
+	// the elements which are not written anymore are deleted in diff mode
+	if t.diff != nil {
+		t.diff.clear(name)
+	}
+
 	// if nothing is in the slice
 	if val.Len() == 0 {
 		return nil
 	}
 
 	// delete the tree below
-	if err := t.deleteTree(name); err != nil && err != store.ErrKeyNotFound {
+	if err := t.deleteTree(ctx, name); err != nil && !errors.Is(err, store.ErrKeyNotFound) {
 		return err
 	}
 
+	errs := new(errorList)
+
 	for i := 0; i < val.Len(); i++ {
-		t.transcode(strings.Join([]string{name, strconv.Itoa(i)}, "/"), val.Index(i))
+		if err := t.transcode(ctx, strings.Join([]string{name, strconv.Itoa(i)}, "/"), val.Index(i)); err != nil {
+			if err := t.fieldError(errs, "["+strconv.Itoa(i)+"]", err); err != nil {
+				return err
+			}
+		}
 	}
 
-	return nil
+	return errs.err()
+}
+
+// transcodeMap
+func (t *transcoder) transcodeMap(ctx context.Context, name string, val reflect.Value) error {
+	// the entries which are not written anymore are deleted in diff mode
+	if t.diff != nil {
+		t.diff.clear(name)
+	}
+
+	// if nothing is in the map
+	if val.Len() == 0 {
+		return nil
+	}
+
+	errs := new(errorList)
+
+	for _, k := range val.MapKeys() {
+		key, err := transcodeMapKey(k)
+		if err != nil {
+			err = newError(t.fullKey(name), k.Type(), nil, err)
+			if err := t.fieldError(errs, fmt.Sprintf("[%v]", k), err); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// map values are not addressable, so we work on a copy
+		v := reflect.New(val.Type().Elem()).Elem()
+		v.Set(val.MapIndex(k))
+
+		if err := t.transcode(ctx, strings.Join([]string{name, key}, "/"), v); err != nil {
+			if err := t.fieldError(errs, "["+key+"]", err); err != nil {
+				return err
+			}
+		}
+	}
+
+	return errs.err()
+}
+
+// transcodeMapKey returns the string representation of a map key.
+// The key is a single path segment, so it can not be empty or contain a slash.
+func transcodeMapKey(k reflect.Value) (string, error) {
+	var key string
+
+	if m, ok := addrInterface(k).(encoding.TextMarshaler); ok {
+		b, err := m.MarshalText()
+		if err != nil {
+			return "", err
+		}
+		key = string(b)
+	} else {
+		switch getKind(k) {
+		case reflect.String:
+			key = k.String()
+		case reflect.Int, reflect.Uint:
+			key = fmt.Sprint(k)
+		default:
+			return "", fmt.Errorf("unsupported map key type '%s'", k.Type())
+		}
+	}
+
+	if key == "" || strings.Contains(key, "/") {
+		return "", fmt.Errorf("invalid map key '%s'", key)
+	}
+
+	return key, nil
 }
 
 // transdecodeStruct
-func (t *transcoder) transcodeStruct(name string, val reflect.Value) error {
-	valInterface := reflect.Indirect(val)
-	valType := valInterface.Type()
+func (t *transcoder) transcodeStruct(ctx context.Context, name string, val reflect.Value) error {
+	if h, ok := addrInterface(val).(BeforeTranscoder); ok && !promotedHook(val.Type(), "BeforeTranscode", t.opts.TagName) {
+		if err := h.BeforeTranscode(); err != nil {
+			return newError(t.fullKey(name), val.Type(), nil, err)
+		}
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	// create an errgroup to trace the latest error and return
 	g, _ := errgroup.WithContext(ctx)
 
+	// all errors of the fields, if they are collected
+	errs := new(errorList)
+
 	// The slice will keep track of all structs we'll be transcoding.
 	// There can be more structs, if we have embedded structs that are squashed.
 	structs := make([]reflect.Value, 1, 5)
@@ -183,34 +681,59 @@ func (t *transcoder) transcodeStruct(name string, val reflect.Value) error {
 	type field struct {
 		field reflect.StructField
 		val   reflect.Value
-		json  bool
+		enc   string
+		tag   string
+		opts  tagOptions
 	}
 	fields := []field{}
 
 	for len(structs) > 0 {
 		structVal := structs[0]
 		structs = structs[1:]
+		structType := structVal.Type()
 
-		for i := 0; i < valType.NumField(); i++ {
-			fieldType := valType.Field(i)
-			isJSON := false
+		for i := 0; i < structType.NumField(); i++ {
+			fieldType := structType.Field(i)
+			fieldVal := structVal.Field(i)
 
-			// detected if this field is json
-			if fieldType.Tag.Get("json") != "" {
-				isJSON = true
+			tag, opts := parseTag(fieldType.Tag.Get(t.opts.TagName))
+			if skipField(fieldType, tag, opts) {
+				continue
+			}
+
+			// the fields of squashed structs are transcoded as fields of this struct
+			if opts.squash() {
+				if fieldVal.Kind() == reflect.Ptr {
+					if fieldVal.IsNil() {
+						continue
+					}
+					fieldVal = fieldVal.Elem()
+				}
+
+				if fieldVal.Kind() == reflect.Struct {
+					structs = append(structs, fieldVal)
+					continue
+				}
+			}
+
+			// fields with a json tag are json, if the json tags are used
+			enc := opts.encoding()
+			if jsonTag := fieldType.Tag.Get("json"); enc == "" && tag == "" && jsonTag != "" && t.opts.JSONTags {
+				if jsonTag == "-" {
+					continue
+				}
+				enc = "json"
 			}
 
-			fields = append(fields, field{fieldType, structVal.Elem().Field(i), isJSON})
+			fields = append(fields, field{fieldType, fieldVal, enc, tag, opts})
 		}
 	}
 
 	// evaluate all fields
 	for _, f := range fields {
-		field, val, isJSON := f.field, f.val, f.json
+		field, val, enc, tag := f.field, f.val, f.enc, f.tag
 		kv := strings.ToLower(field.Name)
 
-		tag := field.Tag.Get(t.opts.TagName)
-		tag = strings.SplitN(tag, ",", 2)[0]
 		if tag != "" {
 			kv = tag
 		}
@@ -223,41 +746,30 @@ func (t *transcoder) transcodeStruct(name string, val reflect.Value) error {
 			continue
 		}
 
-		// we try to deal with json here
-		if isJSON && tag == "" {
-			if !val.CanAddr() {
-				continue
-			}
-
-			// check if we have to omit
-			tag := field.Tag.Get("json")
-			if tag == "-" {
-				continue
-			}
+		// zero values are not written if they should be omitted
+		if f.opts.Has("omitempty") && isEmptyValue(val) {
+			continue
+		}
 
+		// encoded fields are written as a single key
+		if enc != "" {
 			g.Go(func() error {
-				b, err := json.Marshal(val.Interface())
+				b, err := encodeValue(enc, val)
 				if err != nil {
-					return fmt.Errorf("'%s' field got : %s", field.Name, err)
+					return t.fieldError(errs, field.Name, newError(t.fullKey(kv), field.Type, nil, err))
 				}
 
 				// write to kv
-				if err := t.putKVPair(kv, b); err != nil {
-					return fmt.Errorf("'%s' field got : %s", field.Name, err)
-				}
+				err = t.putKVPair(ctx, kv, b)
 
-				return nil
+				return t.fieldError(errs, field.Name, withType(err, field.Type))
 			})
 
 			continue
 		}
 
 		g.Go(func() error {
-			if err := t.transcode(kv, val); err != nil {
-				return err
-			}
-
-			return nil
+			return t.fieldError(errs, field.Name, t.transcode(ctx, kv, val))
 		})
 	}
 
@@ -265,23 +777,78 @@ func (t *transcoder) transcodeStruct(name string, val reflect.Value) error {
 		return err
 	}
 
+	if err := errs.err(); err != nil {
+		return err
+	}
+
+	if h, ok := addrInterface(val).(AfterTranscoder); ok && !promotedHook(val.Type(), "AfterTranscode", t.opts.TagName) {
+		if err := h.AfterTranscode(); err != nil {
+			return newError(t.fullKey(name), val.Type(), nil, err)
+		}
+	}
+
 	return nil
 }
 
+// fieldError adds the field to the path of the error. The error is
+// returned, or added to the list if all errors are collected.
+func (t *transcoder) fieldError(errs *errorList, field string, err error) error {
+	err = withField(err, field)
+
+	if t.opts.CollectErrors {
+		errs.add(err)
+		return nil
+	}
+
+	return err
+}
+
 // transdecodeBasic transdecode a basic type (bool, int, strinc, etc.)
 // and eventually sets it to the retrieved value
 func (t *transcoder) transdecodeBasic(val reflect.Value) error {
 	return nil
 }
 
+// fullKey returns the key in the kv
+func (t *transcoder) fullKey(key string) string {
+	return trailingSlash(t.opts.Prefix) + key
+}
+
 // putKVPair
-func (t *transcoder) putKVPair(key string, value []byte) error {
-	return t.opts.KV.Put(trailingSlash(t.opts.Prefix)+key, value, nil)
+func (t *transcoder) putKVPair(ctx context.Context, key string, value []byte) error {
+	if err := contextError(ctx, t.fullKey(key)); err != nil {
+		return err
+	}
+
+	// the value is written when the diff is applied
+	if t.diff != nil {
+		t.diff.put(key, value)
+	} else if err := t.opts.KV.Put(t.fullKey(key), value, nil); err != nil {
+		return newError(t.fullKey(key), nil, value, err)
+	}
+
+	if t.keys.add(key) && t.opts.Metadata != nil {
+		t.mu.Lock()
+		t.opts.Metadata.Keys = append(t.opts.Metadata.Keys, key)
+		t.mu.Unlock()
+	}
+
+	return nil
 }
 
 // deleteTree
-func (t *transcoder) deleteTree(key string) error {
-	return t.opts.KV.DeleteTree(trailingSlash(t.opts.Prefix) + key)
+func (t *transcoder) deleteTree(ctx context.Context, key string) error {
+	if err := contextError(ctx, t.fullKey(key)); err != nil {
+		return err
+	}
+
+	// the keys are deleted when the diff is applied, unless they are written again
+	if t.diff != nil {
+		t.diff.clear(key)
+		return nil
+	}
+
+	return newError(t.fullKey(key), nil, nil, t.opts.KV.DeleteTree(t.fullKey(key)))
 }
 
 // configureTranscoder
@@ -304,5 +871,13 @@ func configureTranscoder(t *transcoder, opts ...TranscoderOpt) error {
 		t.opts.TagName = defaultTagName
 	}
 
+	if t.opts.FloatFormat == 0 {
+		t.opts.FloatFormat = defaultFloatFormat
+	}
+
+	if t.opts.TimeLayout == "" {
+		t.opts.TimeLayout = defaultTimeLayout
+	}
+
 	return nil
 }
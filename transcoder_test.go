@@ -1,10 +1,16 @@
 package kvstructure_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
+	"reflect"
 	"testing"
+	"time"
 
 	. "github.com/andersnormal/kvstructure"
+	"github.com/andersnormal/kvstructure/memstore"
 	mm "github.com/andersnormal/kvstructure/mock"
 
 	"github.com/docker/libkv/store"
@@ -38,6 +44,7 @@ func TestTranscodeStruct(t *testing.T) {
 	td, err := NewTranscoder(
 		TranscoderWithKV(kv),
 		TranscoderWithPrefix("prefix"),
+		TranscoderWithJSONTags(),
 	)
 
 	tt := &Test{
@@ -135,7 +142,7 @@ func TestTranscodeUint64(t *testing.T) {
 
 func TestTranscodeFloat32(t *testing.T) {
 	s := &mm.Mock{}
-	s.On("Put", "prefix/foo", []byte(fmt.Sprint("float64(9.999)")), mock.Anything).Return(nil)
+	s.On("Put", "prefix/foo", []byte("9.999"), mock.Anything).Return(nil)
 
 	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
 
@@ -144,12 +151,138 @@ func TestTranscodeFloat32(t *testing.T) {
 		TranscoderWithPrefix("prefix"),
 	)
 
+	tt := float32(9.999)
+
+	assert.NoError(t, err)
+
+	err = td.Transcode("foo", &tt)
+	assert.NoError(t, err)
+	s.AssertExpectations(t)
+}
+
+func TestTranscodeFloat64(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("Put", "prefix/foo", []byte("9.999"), mock.Anything).Return(nil)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+
+	tt := float64(9.999)
+
+	assert.NoError(t, err)
+
+	err = td.Transcode("foo", &tt)
+	assert.NoError(t, err)
+	s.AssertExpectations(t)
+}
+
+func TestTranscodeFloatFormat(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("Put", "prefix/foo", []byte("9.99900"), mock.Anything).Return(nil)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+		TranscoderWithFloatFormat('f', 5),
+	)
+
 	tt := float64(9.999)
 
 	assert.NoError(t, err)
 
 	err = td.Transcode("foo", &tt)
 	assert.NoError(t, err)
+	s.AssertExpectations(t)
+}
+
+func TestTranscodeFloatRoundTrip(t *testing.T) {
+	tests := []interface{}{
+		float32(9.999),
+		float32(0.1),
+		float32(math.MaxFloat32),
+		float32(math.SmallestNonzeroFloat32),
+		float64(9.999),
+		float64(0.1),
+		float64(math.MaxFloat64),
+		float64(math.SmallestNonzeroFloat64),
+		math.Inf(1),
+		math.Inf(-1),
+		float32(math.Inf(-1)),
+	}
+
+	for _, tt := range tests {
+		var b []byte
+
+		s := &mm.Mock{}
+		s.On("Put", "prefix/foo", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			b = args.Get(1).([]byte)
+		})
+
+		kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+		tc, err := NewTranscoder(
+			TranscoderWithKV(kv),
+			TranscoderWithPrefix("prefix"),
+		)
+		assert.NoError(t, err)
+
+		in := reflect.New(reflect.TypeOf(tt))
+		in.Elem().Set(reflect.ValueOf(tt))
+
+		err = tc.Transcode("foo", in.Interface())
+		assert.NoError(t, err)
+
+		s.On("Get", "prefix/foo").Return(&store.KVPair{Key: "prefix/foo", Value: b}, nil)
+
+		td, err := NewTransdecoder(
+			TransdecoderWithKV(kv),
+			TransdecoderWithPrefix("prefix"),
+		)
+		assert.NoError(t, err)
+
+		out := reflect.New(reflect.TypeOf(tt))
+
+		err = td.Transdecode("foo", out.Interface())
+		assert.NoError(t, err)
+		assert.Equal(t, tt, out.Elem().Interface(), "value written as '%s'", b)
+	}
+}
+
+func TestTranscodeFloatNaN(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("Put", "prefix/foo", []byte("NaN"), mock.Anything).Return(nil)
+	s.On("Get", "prefix/foo").Return(&store.KVPair{Key: "prefix/foo", Value: []byte("NaN")}, nil)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	tc, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	tt := math.NaN()
+
+	err = tc.Transcode("foo", &tt)
+	assert.NoError(t, err)
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	var out float64
+
+	err = td.Transdecode("foo", &out)
+	assert.NoError(t, err)
+	assert.True(t, math.IsNaN(out))
 }
 
 func TestTranscodeSlice(t *testing.T) {
@@ -172,3 +305,753 @@ func TestTranscodeSlice(t *testing.T) {
 	err = td.Transcode("foo", &tt)
 	assert.NoError(t, err)
 }
+
+type Backend struct {
+	Host string
+	Port int
+}
+
+func TestTranscodeMap(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("Put", "prefix/foo/a/host", []byte("localhost"), mock.Anything).Return(nil)
+	s.On("Put", "prefix/foo/a/port", []byte(fmt.Sprint(80)), mock.Anything).Return(nil)
+	s.On("Put", "prefix/foo/b/host", []byte("remote"), mock.Anything).Return(nil)
+	s.On("Put", "prefix/foo/b/port", []byte(fmt.Sprint(8080)), mock.Anything).Return(nil)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+
+	tt := map[string]Backend{
+		"a": Backend{Host: "localhost", Port: 80},
+		"b": Backend{Host: "remote", Port: 8080},
+	}
+
+	assert.NoError(t, err)
+
+	err = td.Transcode("foo", &tt)
+	assert.NoError(t, err)
+	s.AssertExpectations(t)
+}
+
+func TestTranscodeMapInvalidKey(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	for _, key := range []string{"a/b", ""} {
+		tt := map[string]string{key: "x"}
+
+		err = td.Transcode("foo", &tt)
+
+		var e *Error
+		if assert.True(t, errors.As(err, &e)) {
+			assert.Equal(t, "prefix/foo", e.Key)
+			assert.Contains(t, e.Err.Error(), "invalid map key")
+		}
+	}
+
+	_, err = kv.List("prefix")
+	assert.True(t, errors.Is(err, store.ErrKeyNotFound))
+}
+
+func TestTranscodeMapSlice(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("DeleteTree", "prefix/foo/a", mock.Anything).Return(nil)
+	s.On("Put", "prefix/foo/a/0", []byte("foo"), mock.Anything).Return(nil)
+	s.On("Put", "prefix/foo/a/1", []byte("bar"), mock.Anything).Return(nil)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+
+	tt := map[string][]string{
+		"a": []string{"foo", "bar"},
+	}
+
+	assert.NoError(t, err)
+
+	err = td.Transcode("foo", &tt)
+	assert.NoError(t, err)
+	s.AssertExpectations(t)
+}
+
+func TestTranscodeMapTextMarshaler(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("Put", "prefix/foo/127.0.0.1", []byte("localhost"), mock.Anything).Return(nil)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+
+	tt := map[Addr]string{
+		Addr{127, 0, 0, 1}: "localhost",
+	}
+
+	assert.NoError(t, err)
+
+	err = td.Transcode("foo", &tt)
+	assert.NoError(t, err)
+	s.AssertExpectations(t)
+}
+
+type Pointers struct {
+	Name    *string
+	Backend *Backend
+	Value   interface{}
+}
+
+func TestTranscodePointers(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("Put", "prefix/foo/name", []byte("bar"), mock.Anything).Return(nil)
+	s.On("Put", "prefix/foo/backend/host", []byte("localhost"), mock.Anything).Return(nil)
+	s.On("Put", "prefix/foo/backend/port", []byte(fmt.Sprint(80)), mock.Anything).Return(nil)
+	s.On("Put", "prefix/foo/value/a", []byte("b"), mock.Anything).Return(nil)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+
+	name := "bar"
+	tt := &Pointers{
+		Name:    &name,
+		Backend: &Backend{Host: "localhost", Port: 80},
+		Value:   map[string]string{"a": "b"},
+	}
+
+	assert.NoError(t, err)
+
+	err = td.Transcode("foo", &tt)
+	assert.NoError(t, err)
+	s.AssertExpectations(t)
+}
+
+func TestTranscodeNilSkip(t *testing.T) {
+	s := &mm.Mock{}
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+
+	tt := &Pointers{}
+
+	assert.NoError(t, err)
+
+	err = td.Transcode("foo", &tt)
+	assert.NoError(t, err)
+	s.AssertExpectations(t)
+}
+
+func TestTranscodeNilDelete(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("DeleteTree", "prefix/foo/name").Return(nil)
+	s.On("DeleteTree", "prefix/foo/backend").Return(store.ErrKeyNotFound)
+	s.On("DeleteTree", "prefix/foo/value").Return(nil)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+		TranscoderWithDeleteNil(),
+	)
+
+	tt := &Pointers{}
+
+	assert.NoError(t, err)
+
+	err = td.Transcode("foo", &tt)
+	assert.NoError(t, err)
+	s.AssertExpectations(t)
+}
+
+type Embedded struct {
+	Host string
+}
+
+type Tags struct {
+	Embedded `kvstructure:",squash"`
+	Inline   *Backend `kvstructure:",inline"`
+	Name     string   `kvstructure:"name,omitempty"`
+	Port     int      `kvstructure:",omitempty"`
+	Skip     string   `kvstructure:"-"`
+}
+
+func TestTranscodeTags(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("Put", "prefix/foo/host", []byte("localhost"), mock.Anything).Return(nil)
+	s.On("Put", "prefix/foo/port", []byte(fmt.Sprint(80)), mock.Anything).Return(nil)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+
+	tt := &Tags{
+		Embedded: Embedded{Host: "localhost"},
+		Port:     80,
+		Skip:     "skip",
+	}
+
+	assert.NoError(t, err)
+
+	err = td.Transcode("foo", &tt)
+	assert.NoError(t, err)
+	s.AssertExpectations(t)
+}
+
+func TestTranscodeMetadata(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/stale", []byte("stale"), nil))
+
+	md := new(Metadata)
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+		TranscoderWithMetadata(md),
+	)
+	assert.NoError(t, err)
+
+	tt := &Backend{Host: "localhost", Port: 80}
+
+	err = td.Transcode("foo", tt)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"foo/host", "foo/port"}, md.Keys)
+	assert.ElementsMatch(t, []string{"foo/stale"}, md.Unused)
+}
+
+func TestTranscodeContext(t *testing.T) {
+	s := &mm.Mock{}
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tt := &Backend{Host: "localhost", Port: 80}
+
+	err = td.TranscodeContext(ctx, "foo", tt)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Contains(t, err.Error(), "foo/")
+	s.AssertExpectations(t)
+}
+
+func TestTranscodeError(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("Put", "prefix/foo/host", []byte("localhost"), mock.Anything).Return(store.ErrNotReachable)
+	s.On("Put", "prefix/foo/port", []byte("80"), mock.Anything).Return(store.ErrNotReachable)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+		TranscoderWithCollectErrors(),
+	)
+	assert.NoError(t, err)
+
+	err = td.Transcode("foo", &Backend{Host: "localhost", Port: 80})
+	assert.True(t, errors.Is(err, store.ErrNotReachable))
+
+	var e *MultiError
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Len(t, e.Errors, 2)
+	}
+
+	var ee *Error
+	if assert.True(t, errors.As(err, &ee)) {
+		assert.Contains(t, []string{"Host", "Port"}, ee.Field)
+	}
+}
+
+func TestTranscodeSliceError(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("DeleteTree", "prefix/foo").Return(nil)
+	s.On("Put", "prefix/foo/0", []byte("foo"), mock.Anything).Return(nil)
+	s.On("Put", "prefix/foo/1", []byte("bar"), mock.Anything).Return(store.ErrNotReachable)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	err = td.Transcode("foo", &[]string{"foo", "bar"})
+	assert.True(t, errors.Is(err, store.ErrNotReachable))
+
+	var e *Error
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, "prefix/foo/1", e.Key)
+		assert.Equal(t, "[1]", e.Field)
+	}
+}
+
+func TestTranscodeTimeLayout(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("Put", "prefix/foo", []byte("2019-03-01"), mock.Anything).Return(nil)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+		TranscoderWithTimeLayout("2006-01-02"),
+	)
+	assert.NoError(t, err)
+
+	tt := time.Date(2019, 3, 1, 12, 30, 0, 0, time.UTC)
+
+	err = td.Transcode("foo", &tt)
+	assert.NoError(t, err)
+	s.AssertExpectations(t)
+}
+
+func TestTranscodeStructWithoutJSONTags(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("Put", "prefix/foo/description", []byte("bar"), mock.Anything).Return(nil)
+	s.On("Put", "prefix/foo/condition", []byte("true"), mock.Anything).Return(nil)
+	s.On("Put", "prefix/foo/proto", []byte("baz"), mock.Anything).Return(nil)
+	s.On("Put", "prefix/foo/withomit", []byte(""), mock.Anything).Return(nil)
+	s.On("Put", "prefix/foo/ignore", []byte(""), mock.Anything).Return(nil)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	err = td.Transcode("foo", &Test{Desc: "bar", Cond: true, Proto: "baz"})
+	assert.NoError(t, err)
+	s.AssertExpectations(t)
+}
+
+func TestTranscodeEncodings(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("Put", "prefix/foo/json", []byte(`{"Host":"localhost","Port":80}`), mock.Anything).Return(nil)
+	s.On("Put", "prefix/foo/yaml", []byte("host: localhost\nport: 80\n"), mock.Anything).Return(nil)
+	s.On("Put", "prefix/foo/base64", []byte("Zm9v"), mock.Anything).Return(nil)
+	s.On("Put", "prefix/foo/hex", []byte("666f6f"), mock.Anything).Return(nil)
+	s.On("Put", "prefix/foo/csv", []byte(`a,"b,c"`), mock.Anything).Return(nil)
+	s.On("Put", "prefix/foo/raw", []byte("foo"), mock.Anything).Return(nil)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	err = td.Transcode("foo", &Encodings{
+		JSON:   Backend{Host: "localhost", Port: 80},
+		YAML:   map[string]interface{}{"host": "localhost", "port": 80},
+		Base64: []byte("foo"),
+		Hex:    "foo",
+		CSV:    []string{"a", "b,c"},
+		Raw:    []byte("foo"),
+	})
+	assert.NoError(t, err)
+	s.AssertExpectations(t)
+}
+
+func TestTranscodeDiff(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	type Pool struct {
+		Name    string
+		Servers []string
+	}
+
+	tt := &Pool{Name: "pool", Servers: []string{"a", "b", "c"}}
+
+	cs, err := td.(DiffTranscoder).TranscodeDiff(context.Background(), "foo", tt)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo/name", "foo/servers/0", "foo/servers/1", "foo/servers/2"}, cs.Puts)
+	assert.Empty(t, cs.Deletes)
+
+	name, err := kv.Get("prefix/foo/name")
+	assert.NoError(t, err)
+
+	tt.Servers = []string{"a", "x"}
+
+	cs, err = td.(DiffTranscoder).TranscodeDiff(context.Background(), "foo", tt)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo/servers/1"}, cs.Puts)
+	assert.Equal(t, []string{"foo/servers/2"}, cs.Deletes)
+
+	kvPair, err := kv.Get("prefix/foo/name")
+	assert.NoError(t, err)
+	assert.Equal(t, name.LastIndex, kvPair.LastIndex)
+
+	kvPair, err = kv.Get("prefix/foo/servers/1")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("x"), kvPair.Value)
+
+	_, err = kv.Get("prefix/foo/servers/2")
+	assert.True(t, errors.Is(err, store.ErrKeyNotFound))
+
+	cs, err = td.(DiffTranscoder).TranscodeDiff(context.Background(), "foo", tt)
+	assert.NoError(t, err)
+	assert.Empty(t, cs.Puts)
+	assert.Empty(t, cs.Deletes)
+}
+
+func TestTranscodeDiffKeepsUnknownKeys(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/other", []byte("other"), nil))
+
+	md := new(Metadata)
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+		TranscoderWithMetadata(md),
+	)
+	assert.NoError(t, err)
+
+	tt := &Backend{Host: "localhost", Port: 80}
+
+	cs, err := td.(DiffTranscoder).TranscodeDiff(context.Background(), "foo", tt)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo/host", "foo/port"}, cs.Puts)
+	assert.Empty(t, cs.Deletes)
+	assert.ElementsMatch(t, []string{"foo/other"}, md.Unused)
+}
+
+func TestTranscodePrune(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/removed", []byte("removed"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/servers/0", []byte("a"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/owner/id", []byte("1"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/lock-a", []byte("1"), nil))
+	assert.NoError(t, kv.Put("prefix/bar/other", []byte("other"), nil))
+
+	md := new(Metadata)
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+		TranscoderWithMetadata(md),
+		TranscoderWithPrune("foo/owner", "foo/lock-*"),
+	)
+	assert.NoError(t, err)
+
+	type Pool struct {
+		Name    string
+		Servers []string
+	}
+
+	tt := &Pool{Name: "pool", Servers: []string{}}
+
+	err = td.Transcode("foo", tt)
+	assert.NoError(t, err)
+
+	kvPairs, err := kv.List("prefix")
+	assert.NoError(t, err)
+
+	keys := make([]string, 0, len(kvPairs))
+	for _, kvPair := range kvPairs {
+		keys = append(keys, kvPair.Key)
+	}
+
+	assert.ElementsMatch(t, []string{"prefix/foo/name", "prefix/foo/owner/id", "prefix/foo/lock-a", "prefix/bar/other"}, keys)
+	assert.ElementsMatch(t, []string{"foo/owner/id", "foo/lock-a"}, md.Unused)
+}
+
+func TestTranscodeDiffPrune(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/removed", []byte("removed"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/owner/id", []byte("1"), nil))
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+		TranscoderWithPrune("foo/owner"),
+	)
+	assert.NoError(t, err)
+
+	tt := &Backend{Host: "localhost", Port: 80}
+
+	cs, err := td.(DiffTranscoder).TranscodeDiff(context.Background(), "foo", tt)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo/host", "foo/port"}, cs.Puts)
+	assert.Equal(t, []string{"foo/removed"}, cs.Deletes)
+
+	_, err = kv.Get("prefix/foo/owner/id")
+	assert.NoError(t, err)
+}
+
+type inner struct {
+	A string
+}
+
+type Outer struct {
+	inner
+	B string
+}
+
+func TestTranscodeUnexportedEmbedded(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+
+	tc, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	err = tc.Transcode("foo", &Outer{inner: inner{A: "a"}, B: "b"})
+	assert.NoError(t, err)
+
+	kvPair, err := kv.Get("prefix/foo/b")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "b", string(kvPair.Value))
+	}
+
+	// unexported embedded structs are only used if they are squashed
+	_, err = kv.Get("prefix/foo/inner/a")
+	assert.True(t, errors.Is(err, store.ErrKeyNotFound))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithValidate(func(v interface{}) error { return nil }),
+	)
+	assert.NoError(t, err)
+
+	var out Outer
+
+	err = td.Transdecode("foo", &out)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", out.B)
+}
+
+type labels map[string]string
+
+type extra struct {
+	C string
+}
+
+type Squashed struct {
+	inner  `kvstructure:",squash"`
+	*extra `kvstructure:",squash"`
+	labels
+	B string
+}
+
+func TestTranscodeUnexportedEmbeddedFields(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+
+	tc, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	err = tc.Transcode("foo", &Squashed{inner: inner{A: "a"}, labels: labels{"env": "prod"}, B: "b"})
+	assert.NoError(t, err)
+
+	kvPairs, err := kv.List("prefix/foo")
+	if assert.NoError(t, err) {
+		keys := make([]string, len(kvPairs))
+		for i, kvPair := range kvPairs {
+			keys[i] = kvPair.Key
+		}
+
+		assert.ElementsMatch(t, []string{"prefix/foo/a", "prefix/foo/b"}, keys)
+	}
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithStrict(),
+	)
+	assert.NoError(t, err)
+
+	var out Squashed
+
+	err = td.Transdecode("foo", &out)
+	assert.NoError(t, err)
+	assert.Equal(t, Squashed{inner: inner{A: "a"}, B: "b"}, out)
+}
+
+func TestTranscodePruneSiblings(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("Put", "prefix/foo/host", []byte("localhost"), mock.Anything).Return(nil)
+	s.On("Put", "prefix/foo/port", []byte("80"), mock.Anything).Return(nil)
+	s.On("List", "prefix/foo").Return(
+		[]*store.KVPair{
+			&store.KVPair{Key: "prefix/foo/stale", Value: []byte("stale")},
+			&store.KVPair{Key: "prefix/foobar/other", Value: []byte("other")},
+		},
+		nil,
+	)
+	s.On("Delete", "prefix/foo/stale").Return(nil)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+		TranscoderWithPrune(),
+	)
+	assert.NoError(t, err)
+
+	err = td.Transcode("foo", &Backend{Host: "localhost", Port: 80})
+	assert.NoError(t, err)
+	s.AssertExpectations(t)
+	s.AssertNotCalled(t, "Delete", "prefix/foobar/other")
+}
+
+func TestTranscodeDiffPruneSiblings(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("Get", "prefix/foo").Return((*store.KVPair)(nil), store.ErrKeyNotFound)
+	s.On("List", "prefix/foo").Return(
+		[]*store.KVPair{
+			&store.KVPair{Key: "prefix/foo/host", Value: []byte("localhost")},
+			&store.KVPair{Key: "prefix/foo/port", Value: []byte("80")},
+			&store.KVPair{Key: "prefix/foobar/other", Value: []byte("other")},
+		},
+		nil,
+	)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+		TranscoderWithPrune(),
+	)
+	assert.NoError(t, err)
+
+	cs, err := td.(DiffTranscoder).TranscodeDiff(context.Background(), "foo", &Backend{Host: "localhost", Port: 80})
+	assert.NoError(t, err)
+	assert.Empty(t, cs.Puts)
+	assert.Empty(t, cs.Deletes)
+	s.AssertNotCalled(t, "Delete", "prefix/foobar/other")
+}
+
+func TestTranscodeMetadataSiblings(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("Put", "prefix/foo/host", []byte("localhost"), mock.Anything).Return(nil)
+	s.On("Put", "prefix/foo/port", []byte("80"), mock.Anything).Return(nil)
+	s.On("List", "prefix/foo").Return(
+		[]*store.KVPair{
+			&store.KVPair{Key: "prefix/foo/host", Value: []byte("localhost")},
+			&store.KVPair{Key: "prefix/foo/port", Value: []byte("80")},
+			&store.KVPair{Key: "prefix/foo/stale", Value: []byte("stale")},
+			&store.KVPair{Key: "prefix/foobar/other", Value: []byte("other")},
+		},
+		nil,
+	)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	md := new(Metadata)
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+		TranscoderWithMetadata(md),
+	)
+	assert.NoError(t, err)
+
+	err = td.Transcode("foo", &Backend{Host: "localhost", Port: 80})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo/stale"}, md.Unused)
+}
+
+func TestTranscodeDiffMap(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	tt := map[string]string{"a": "a", "b": "b"}
+
+	cs, err := td.(DiffTranscoder).TranscodeDiff(context.Background(), "foo", &tt)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo/a", "foo/b"}, cs.Puts)
+
+	tt = map[string]string{"a": "a"}
+
+	cs, err = td.(DiffTranscoder).TranscodeDiff(context.Background(), "foo", &tt)
+	assert.NoError(t, err)
+	assert.Empty(t, cs.Puts)
+	assert.Equal(t, []string{"foo/b"}, cs.Deletes)
+
+	_, err = kv.Get("prefix/foo/b")
+	assert.True(t, errors.Is(err, store.ErrKeyNotFound))
+}
+
+func TestTranscodeDiffSliceEmpty(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+
+	td, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	type Pool struct {
+		Name  string
+		Items []string
+	}
+
+	tt := &Pool{Name: "pool", Items: []string{"a", "b"}}
+
+	_, err = td.(DiffTranscoder).TranscodeDiff(context.Background(), "foo", tt)
+	assert.NoError(t, err)
+
+	tt.Items = nil
+
+	cs, err := td.(DiffTranscoder).TranscodeDiff(context.Background(), "foo", tt)
+	assert.NoError(t, err)
+	assert.Empty(t, cs.Puts)
+	assert.Equal(t, []string{"foo/items/0", "foo/items/1"}, cs.Deletes)
+
+	kvPairs, err := kv.List("prefix/foo")
+	if assert.NoError(t, err) {
+		assert.Len(t, kvPairs, 1)
+	}
+}
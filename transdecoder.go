@@ -2,12 +2,14 @@ package kvstructure
 
 import (
 	"context"
-	"encoding/json"
+	"encoding"
 	"errors"
 	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/docker/libkv/store"
 	"golang.org/x/sync/errgroup"
@@ -35,6 +37,7 @@ func NewTransdecoder(opts ...TransdecoderOpt) (Transdecoder, error) {
 
 	t := new(transdecoder)
 	t.opts = options
+	t.mu = new(sync.Mutex)
 
 	// configure transcoder
 	configureTransdecoder(t, opts...)
@@ -56,8 +59,117 @@ func TransdecoderWithKV(kv store.Store) func(o *TransdecoderOpts) {
 	}
 }
 
+// TransdecoderWithZeroFields zeros maps, slices and structs before they are decoded
+func TransdecoderWithZeroFields() func(o *TransdecoderOpts) {
+	return func(o *TransdecoderOpts) {
+		o.ZeroFields = true
+	}
+}
+
+// TransdecoderWithWeaklyTypedInput accepts values in other formats than
+// the one which is written by the transcoder
+func TransdecoderWithWeaklyTypedInput() func(o *TransdecoderOpts) {
+	return func(o *TransdecoderOpts) {
+		o.WeaklyTypedInput = true
+	}
+}
+
+// TransdecoderWithCollectErrors returns all errors instead of the first error
+func TransdecoderWithCollectErrors() func(o *TransdecoderOpts) {
+	return func(o *TransdecoderOpts) {
+		o.CollectErrors = true
+	}
+}
+
+// TransdecoderWithValidate calls the function for every decoded struct
+func TransdecoderWithValidate(f ValidateFunc) func(o *TransdecoderOpts) {
+	return func(o *TransdecoderOpts) {
+		o.Validate = f
+	}
+}
+
+// TransdecoderWithDecodeHook calls the hook with the raw values before they
+// are decoded. Multiple hooks are called in the order they are given.
+func TransdecoderWithDecodeHook(f DecodeHookFunc) func(o *TransdecoderOpts) {
+	return func(o *TransdecoderOpts) {
+		if o.DecodeHook != nil {
+			f = ComposeDecodeHookFunc(o.DecodeHook, f)
+		}
+		o.DecodeHook = f
+	}
+}
+
+// TransdecoderWithWriteDefaults writes the default values of fields to the kv,
+// if there is nothing stored at their key
+func TransdecoderWithWriteDefaults() func(o *TransdecoderOpts) {
+	return func(o *TransdecoderOpts) {
+		o.WriteDefaults = true
+	}
+}
+
+// TransdecoderWithJSONTags decodes fields with a json tag from JSON
+func TransdecoderWithJSONTags() func(o *TransdecoderOpts) {
+	return func(o *TransdecoderOpts) {
+		o.JSONTags = true
+	}
+}
+
+// TransdecoderWithCodec uses the codec to decode the values of the type
+func TransdecoderWithCodec(typ reflect.Type, c Codec) func(o *TransdecoderOpts) {
+	return func(o *TransdecoderOpts) {
+		if o.Codecs == nil {
+			o.Codecs = make(map[reflect.Type]Codec)
+		}
+		o.Codecs[typ] = c
+	}
+}
+
+// TransdecoderWithTimeLayout sets the layout that is used to parse a time.Time
+func TransdecoderWithTimeLayout(layout string) func(o *TransdecoderOpts) {
+	return func(o *TransdecoderOpts) {
+		o.TimeLayout = layout
+	}
+}
+
+// TransdecoderWithStrict reports keys that do not fit the value as errors,
+// e.g. unknown keys or slice elements with an index that is not a number
+func TransdecoderWithStrict() func(o *TransdecoderOpts) {
+	return func(o *TransdecoderOpts) {
+		o.Strict = true
+	}
+}
+
+// TransdecoderWithPartialSlices keeps the elements of slices which have been
+// decoded, if other elements fail to decode
+func TransdecoderWithPartialSlices() func(o *TransdecoderOpts) {
+	return func(o *TransdecoderOpts) {
+		o.PartialSlices = true
+	}
+}
+
+// TransdecoderWithBulk lists all keys once and decodes from this view
+func TransdecoderWithBulk() func(o *TransdecoderOpts) {
+	return func(o *TransdecoderOpts) {
+		o.Bulk = true
+	}
+}
+
+// TransdecoderWithMetadata tracks the read keys, and the keys
+// that have been found but not read, in the metadata
+func TransdecoderWithMetadata(md *Metadata) func(o *TransdecoderOpts) {
+	return func(o *TransdecoderOpts) {
+		o.Metadata = md
+	}
+}
+
 // Transdecode transdecodes a given raw interface to a filled structure
 func (t *transdecoder) Transdecode(name string, s interface{}) error {
+	return t.TransdecodeContext(context.Background(), name, s)
+}
+
+// TransdecodeContext transdecodes a given raw interface to a filled structure.
+// No more data is read from the kv once the context is done.
+func (t *transdecoder) TransdecodeContext(ctx context.Context, name string, s interface{}) error {
 	val := reflect.ValueOf(s)
 	if val.Kind() != reflect.Ptr {
 		return errors.New("kvstructure: interface must be a pointer")
@@ -68,36 +180,248 @@ func (t *transdecoder) Transdecode(name string, s interface{}) error {
 		return errors.New("kvstructure: interface must be addressable (a pointer)")
 	}
 
-	return t.transdecode(name, reflect.ValueOf(s).Elem(), nil)
+	td := t.fork()
+	if t.opts.Bulk {
+		kvPairs, err := td.listKVPairs(ctx, name)
+		if err != nil {
+			return err
+		}
+		td.snapshot = newSnapshot(trailingSlash(t.opts.Prefix)+name, kvPairs)
+	}
+
+	return td.run(ctx, name, val)
+}
+
+// run transdecodes the value in a forked transdecoder
+func (t *transdecoder) run(ctx context.Context, name string, val reflect.Value) error {
+	err := t.transdecode(ctx, name, val, nil)
+	if err != nil && !t.opts.Strict {
+		return err
+	}
+
+	// all violations are reported in strict mode
+	errs := new(errorList)
+	errs.add(err)
+	errs.add(t.trackUnused(ctx, name))
+
+	return errs.err()
+}
+
+// fork returns a copy of the transdecoder for a single run
+func (t *transdecoder) fork() *transdecoder {
+	return &transdecoder{
+		opts: t.opts,
+		mu:   t.mu,
+		keys: newKeySet(),
+	}
+}
+
+// trackUnused adds the keys below the name that have not been read
+// in this run to the metadata, and reports them as unknown in strict mode
+func (t *transdecoder) trackUnused(ctx context.Context, name string) error {
+	if t.opts.Metadata == nil && !t.opts.Strict {
+		return nil
+	}
+
+	kvPairs, err := t.listKVPairs(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	errs := new(errorList)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, kvPair := range kvPairs {
+		if !inTree(kvPair.Key, t.fullKey(name)) {
+			continue
+		}
+
+		key := strings.TrimPrefix(kvPair.Key, trailingSlash(t.opts.Prefix))
+		if t.keys.has(key) {
+			continue
+		}
+
+		if t.opts.Metadata != nil {
+			t.opts.Metadata.Unused = append(t.opts.Metadata.Unused, key)
+		}
+
+		if t.opts.Strict {
+			errs.add(&Error{Key: kvPair.Key, Value: kvPair.Value, Err: ErrUnknownKey})
+		}
+	}
+
+	return errs.err()
 }
 
 // transdecode is doing the heavy lifting in the background
-func (t *transdecoder) transdecode(name string, val reflect.Value, kvPair *store.KVPair) error {
+func (t *transdecoder) transdecode(ctx context.Context, name string, val reflect.Value, kvPair *store.KVPair) error {
+	switch val.Kind() {
+	case reflect.Ptr:
+		return t.transdecodePtr(ctx, name, val, kvPair)
+	case reflect.Interface:
+		return t.transdecodeInterface(ctx, name, val, kvPair)
+	}
+
+	// the raw values of single keys are passed to the decode hook
+	if t.opts.DecodeHook != nil && t.isSingleKey(val) {
+		var done bool
+		var err error
+
+		kvPair, done, err = t.decodeHook(ctx, name, val, kvPair)
+		if err != nil || done {
+			return withType(err, val.Type())
+		}
+	}
+
+	// values of a type with a codec are read from a single key
+	if c, ok := t.opts.Codecs[val.Type()]; ok {
+		return withType(t.transdecodeCodec(ctx, name, val, kvPair, c), val.Type())
+	}
+
+	// values that read their own keys are not reflected
+	if unmarshalsKV(val.Type()) {
+		return withType(t.transdecodeKV(ctx, name, val, kvPair), val.Type())
+	}
+
+	// values with a text representation are read from a single key
+	if isText(val.Type()) {
+		return withType(t.transdecodeText(ctx, name, val, kvPair), val.Type())
+	}
+
 	var err error
-	valKind := getKind(reflect.Indirect(val))
+	valKind := getKind(val)
 	switch valKind {
 	case reflect.String:
-		err = t.transdecodeString(name, val, kvPair)
+		err = t.transdecodeString(ctx, name, val, kvPair)
 	case reflect.Bool:
-		err = t.transdecodeBool(name, val, kvPair)
+		err = t.transdecodeBool(ctx, name, val, kvPair)
 	case reflect.Int:
-		err = t.transdecodeInt(name, val, kvPair)
+		err = t.transdecodeInt(ctx, name, val, kvPair)
 	case reflect.Uint:
-		err = t.transdecodeUint(name, val, kvPair)
+		err = t.transdecodeUint(ctx, name, val, kvPair)
 	case reflect.Float32:
-		err = t.transdecodeFloat(name, val, kvPair)
+		err = t.transdecodeFloat(ctx, name, val, kvPair)
 	case reflect.Struct:
-		err = t.transdecodeStruct(name, val)
+		err = t.transdecodeStruct(ctx, name, val)
 	case reflect.Slice:
 		// silent do nothing
-		err = t.transdecodeSlice(name, val)
+		err = t.transdecodeSlice(ctx, name, val)
+	case reflect.Array:
+		err = t.transdecodeArray(ctx, name, val)
+	case reflect.Map:
+		err = t.transdecodeMap(ctx, name, val)
 	default:
 		// we have to work on here for value to pointed to
-		return fmt.Errorf("kvstructure: unsupported type %s", valKind)
+		return newError(t.fullKey(name), val.Type(), nil, fmt.Errorf("unsupported type %s", valKind))
 	}
 
 	// should be nil
-	return err
+	return withType(err, val.Type())
+}
+
+// transdecodePtr leaves the pointer as is if there is nothing stored at the key,
+// otherwise it allocates the value that is pointed to if necessary and decodes into it
+func (t *transdecoder) transdecodePtr(ctx context.Context, name string, val reflect.Value, kvPair *store.KVPair) error {
+	if kvPair == nil {
+		var err error
+		var ok bool
+
+		elem := reflect.New(val.Type().Elem()).Elem()
+
+		tree := false
+		switch getKind(elem) {
+		case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array, reflect.Interface:
+			tree = !t.isLeaf(elem.Type())
+		}
+
+		// values that read their own keys may have a value and keys below
+		kv := unmarshalsKV(elem.Type())
+
+		if tree || kv {
+			ok, err = t.hasChildren(ctx, name)
+		}
+
+		if err == nil && !ok && (!tree || kv) {
+			kvPair, err = t.getKVPair(ctx, name, nil)
+			if errors.Is(err, store.ErrKeyNotFound) {
+				err = nil
+			}
+			ok = kvPair != nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return nil
+		}
+	}
+
+	if val.IsNil() {
+		val.Set(reflect.New(val.Type().Elem()))
+	}
+
+	return t.transdecode(ctx, name, val.Elem(), kvPair)
+}
+
+// transdecodeInterface decodes into the value of the interface if it holds
+// a non-nil pointer. Otherwise, it decodes to a generic value tree
+// of strings, map[string]interface{} and []interface{}.
+func (t *transdecoder) transdecodeInterface(ctx context.Context, name string, val reflect.Value, kvPair *store.KVPair) error {
+	if !val.IsNil() && val.Elem().Kind() == reflect.Ptr && !val.Elem().IsNil() {
+		return t.transdecode(ctx, name, val.Elem(), kvPair)
+	}
+
+	if kvPair == nil {
+		var err error
+
+		kvPair, err = t.getKVPair(ctx, name, nil)
+		if err != nil && !errors.Is(err, store.ErrKeyNotFound) {
+			return err
+		}
+	}
+
+	// only the empty interface can hold the generic string, map or slice
+	generic := val.Type().NumMethod() == 0
+
+	if kvPair != nil {
+		if !generic {
+			return newError(t.fullKey(name), val.Type(), kvPair.Value, errors.New("no concrete type to decode into"))
+		}
+
+		val.Set(reflect.ValueOf(string(kvPair.Value)))
+
+		return nil
+	}
+
+	kvPairs, err := t.listKVPairs(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	tree := genericTree(trailingSlash(t.opts.Prefix)+name, kvPairs)
+	if tree == nil {
+		return nil
+	}
+
+	if !generic {
+		return newError(t.fullKey(name), val.Type(), nil, errors.New("no concrete type to decode into"))
+	}
+
+	// all the pairs below the name are read into the tree
+	for _, kvPair := range kvPairs {
+		key := strings.TrimPrefix(kvPair.Key, trailingSlash(t.opts.Prefix))
+		if strings.HasPrefix(key, trailingSlash(name)) {
+			t.getKVPair(ctx, key, kvPair)
+		}
+	}
+
+	val.Set(reflect.ValueOf(tree))
+
+	return nil
 }
 
 // transdecodeBasic transdecode a basic type (bool, int, strinc, etc.)
@@ -106,9 +430,134 @@ func (t *transdecoder) transdecodeBasic(val reflect.Value) error {
 	return nil
 }
 
+// decodeHook passes the raw value to the decode hook. It returns the
+// pair with the raw value to decode, or true if the value has been set.
+func (t *transdecoder) decodeHook(ctx context.Context, name string, val reflect.Value, kvPair *store.KVPair) (*store.KVPair, bool, error) {
+	kvPair, err := t.getKVPair(ctx, name, kvPair)
+	if err != nil {
+		return nil, false, err
+	}
+
+	v, err := t.opts.DecodeHook(kvPair.Value, val.Type())
+	if err != nil {
+		return nil, false, newError(t.fullKey(name), val.Type(), kvPair.Value, err)
+	}
+
+	if v == nil {
+		return kvPair, false, nil
+	}
+
+	if raw, ok := rawValue(v); ok {
+		return &store.KVPair{Key: kvPair.Key, Value: raw, LastIndex: kvPair.LastIndex}, false, nil
+	}
+
+	if err := setHookValue(val, v); err != nil {
+		return nil, false, newError(t.fullKey(name), val.Type(), kvPair.Value, err)
+	}
+
+	return kvPair, true, nil
+}
+
+// transdecodeKV reads the value at the name and all the keys below it
+// into a KVUnmarshaler
+func (t *transdecoder) transdecodeKV(ctx context.Context, name string, val reflect.Value, kvPair *store.KVPair) error {
+	if !val.CanAddr() {
+		return newError(t.fullKey(name), val.Type(), nil, fmt.Errorf("got unaddressable value of type '%s'", val.Type()))
+	}
+
+	kvPair, err := t.getKVPair(ctx, name, kvPair)
+	if err != nil && !errors.Is(err, store.ErrKeyNotFound) {
+		return err
+	}
+
+	kvs := make(map[string][]byte)
+	if kvPair != nil {
+		kvs[""] = kvPair.Value
+	}
+
+	kvPairs, err := t.listKVPairs(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	for _, kvPair := range kvPairs {
+		key := strings.TrimPrefix(kvPair.Key, trailingSlash(t.opts.Prefix))
+		if !strings.HasPrefix(key, trailingSlash(name)) {
+			continue
+		}
+
+		t.getKVPair(ctx, key, kvPair)
+		kvs[strings.TrimPrefix(key, trailingSlash(name))] = kvPair.Value
+	}
+
+	if err := val.Addr().Interface().(KVUnmarshaler).UnmarshalKV(kvs); err != nil {
+		return newError(t.fullKey(name), val.Type(), nil, err)
+	}
+
+	return nil
+}
+
+// transdecodeCodec sets the value that is decoded by the codec
+func (t *transdecoder) transdecodeCodec(ctx context.Context, name string, val reflect.Value, kvPair *store.KVPair, c Codec) error {
+	kvPair, err := t.getKVPair(ctx, name, kvPair)
+	if err != nil {
+		return err
+	}
+
+	if !val.CanAddr() {
+		return newError(t.fullKey(name), val.Type(), kvPair.Value, fmt.Errorf("got unaddressable value of type '%s'", val.Type()))
+	}
+
+	if err := c.Decode(kvPair.Value, val.Addr().Interface()); err != nil {
+		return newError(t.fullKey(name), val.Type(), kvPair.Value, err)
+	}
+
+	return nil
+}
+
+// transdecodeText reads durations, times and text unmarshalers from a single key
+func (t *transdecoder) transdecodeText(ctx context.Context, name string, val reflect.Value, kvPair *store.KVPair) error {
+	kvPair, err := t.getKVPair(ctx, name, kvPair)
+	if err != nil {
+		return err
+	}
+
+	kvVal := string(kvPair.Value)
+
+	switch val.Type() {
+	case durationType:
+		d, err := time.ParseDuration(kvVal)
+		if err != nil && t.opts.WeaklyTypedInput {
+			var n int64
+			n, err = weakParseInt(kvVal, 64)
+			d = time.Duration(n)
+		}
+		if err != nil {
+			return newError(t.fullKey(name), val.Type(), kvPair.Value, err)
+		}
+		val.SetInt(int64(d))
+	case timeType:
+		tm, err := time.Parse(t.opts.TimeLayout, kvVal)
+		if err != nil {
+			return newError(t.fullKey(name), val.Type(), kvPair.Value, err)
+		}
+		val.Set(reflect.ValueOf(tm))
+	default:
+		if !val.CanAddr() {
+			return newError(t.fullKey(name), val.Type(), kvPair.Value, fmt.Errorf("got unaddressable value of type '%s'", val.Type()))
+		}
+
+		if err := val.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText(kvPair.Value); err != nil {
+			return newError(t.fullKey(name), val.Type(), kvPair.Value, err)
+		}
+	}
+
+	return nil
+}
+
 // transdecodeString
-func (t *transdecoder) transdecodeString(name string, val reflect.Value, kvPair *store.KVPair) error {
-	kvPair, err := t.getKVPair(name, kvPair)
+func (t *transdecoder) transdecodeString(ctx context.Context, name string, val reflect.Value, kvPair *store.KVPair) error {
+	kvPair, err := t.getKVPair(ctx, name, kvPair)
 	if err != nil {
 		return err
 	}
@@ -132,8 +581,8 @@ func (t *transdecoder) transdecodeString(name string, val reflect.Value, kvPair
 }
 
 // transdecodeBool
-func (t *transdecoder) transdecodeBool(name string, val reflect.Value, kvPair *store.KVPair) error {
-	kvPair, err := t.getKVPair(name, kvPair)
+func (t *transdecoder) transdecodeBool(ctx context.Context, name string, val reflect.Value, kvPair *store.KVPair) error {
+	kvPair, err := t.getKVPair(ctx, name, kvPair)
 	if err != nil {
 		return err
 	}
@@ -143,20 +592,23 @@ func (t *transdecoder) transdecodeBool(name string, val reflect.Value, kvPair *s
 	switch {
 	case val.Kind() == reflect.Bool:
 		conv, err := strconv.ParseBool(kvVal)
+		if err != nil && t.opts.WeaklyTypedInput {
+			conv, err = weakParseBool(kvVal)
+		}
 		if err != nil {
-			return err
+			return newError(t.fullKey(name), val.Type(), kvPair.Value, err)
 		}
 		val.SetBool(conv)
 	default:
-		return fmt.Errorf("'%s' got unconvertible type '%s'", name, val.Type())
+		return newError(t.fullKey(name), val.Type(), kvPair.Value, fmt.Errorf("got unconvertible type '%s'", val.Type()))
 	}
 
 	return nil
 }
 
 // transdecodeInt
-func (t *transdecoder) transdecodeInt(name string, val reflect.Value, kvPair *store.KVPair) error {
-	kvPair, err := t.getKVPair(name, kvPair)
+func (t *transdecoder) transdecodeInt(ctx context.Context, name string, val reflect.Value, kvPair *store.KVPair) error {
+	kvPair, err := t.getKVPair(ctx, name, kvPair)
 	if err != nil {
 		return err
 	}
@@ -164,28 +616,34 @@ func (t *transdecoder) transdecodeInt(name string, val reflect.Value, kvPair *st
 	kvVal := string(kvPair.Value)
 
 	switch {
-	case val.Kind() == reflect.Int:
-		conv, err := strconv.ParseInt(kvVal, 10, 64)
+	case getKind(val) == reflect.Int:
+		conv, err := strconv.ParseInt(kvVal, 10, val.Type().Bits())
+		if err != nil && t.opts.WeaklyTypedInput {
+			conv, err = weakParseInt(kvVal, val.Type().Bits())
+		}
 		if err != nil {
-			return err
+			return newError(t.fullKey(name), val.Type(), kvPair.Value, err)
 		}
 		val.SetInt(conv)
-	case val.Kind() == reflect.Uint:
-		conv, err := strconv.ParseUint(kvVal, 10, 64)
+	case getKind(val) == reflect.Uint:
+		conv, err := strconv.ParseUint(kvVal, 10, val.Type().Bits())
+		if err != nil && t.opts.WeaklyTypedInput {
+			conv, err = weakParseUint(kvVal, val.Type().Bits())
+		}
 		if err != nil {
-			return err
+			return newError(t.fullKey(name), val.Type(), kvPair.Value, err)
 		}
 		val.SetUint(conv)
 	default:
-		return fmt.Errorf("'%s' got unconvertible type '%s'", name, val.Type())
+		return newError(t.fullKey(name), val.Type(), kvPair.Value, fmt.Errorf("got unconvertible type '%s'", val.Type()))
 	}
 
 	return nil
 }
 
 // transdecodeUint
-func (t *transdecoder) transdecodeUint(name string, val reflect.Value, kvPair *store.KVPair) error {
-	kvPair, err := t.getKVPair(name, kvPair)
+func (t *transdecoder) transdecodeUint(ctx context.Context, name string, val reflect.Value, kvPair *store.KVPair) error {
+	kvPair, err := t.getKVPair(ctx, name, kvPair)
 	if err != nil {
 		return err
 	}
@@ -193,22 +651,25 @@ func (t *transdecoder) transdecodeUint(name string, val reflect.Value, kvPair *s
 	kvVal := string(kvPair.Value)
 
 	switch {
-	case val.Kind() == reflect.Uint:
-		conv, err := strconv.ParseUint(kvVal, 10, 64)
+	case getKind(val) == reflect.Uint:
+		conv, err := strconv.ParseUint(kvVal, 10, val.Type().Bits())
+		if err != nil && t.opts.WeaklyTypedInput {
+			conv, err = weakParseUint(kvVal, val.Type().Bits())
+		}
 		if err != nil {
-			return err
+			return newError(t.fullKey(name), val.Type(), kvPair.Value, err)
 		}
 		val.SetUint(conv)
 	default:
-		return fmt.Errorf("'%s' got unconvertible type '%s'", name, val.Type())
+		return newError(t.fullKey(name), val.Type(), kvPair.Value, fmt.Errorf("got unconvertible type '%s'", val.Type()))
 	}
 
 	return nil
 }
 
-// transdecodeFloat32
-func (t *transdecoder) transdecodeFloat(name string, val reflect.Value, kvPair *store.KVPair) error {
-	kvPair, err := t.getKVPair(name, kvPair)
+// transdecodeFloat
+func (t *transdecoder) transdecodeFloat(ctx context.Context, name string, val reflect.Value, kvPair *store.KVPair) error {
+	kvPair, err := t.getKVPair(ctx, name, kvPair)
 	if err != nil {
 		return err
 	}
@@ -216,30 +677,43 @@ func (t *transdecoder) transdecodeFloat(name string, val reflect.Value, kvPair *
 	kvVal := string(kvPair.Value)
 
 	switch {
-	case val.Kind() == reflect.Float32:
-		conv, err := strconv.ParseFloat(kvVal, 64)
+	case getKind(val) == reflect.Float32:
+		conv, err := strconv.ParseFloat(kvVal, val.Type().Bits())
+		if err != nil && t.opts.WeaklyTypedInput {
+			conv, err = weakParseFloat(kvVal, val.Type().Bits())
+		}
 		if err != nil {
-			return err
+			return newError(t.fullKey(name), val.Type(), kvPair.Value, err)
 		}
 		val.SetFloat(conv)
 	default:
-		return fmt.Errorf("'%s' got unconvertible type '%s'", name, val.Type())
+		return newError(t.fullKey(name), val.Type(), kvPair.Value, fmt.Errorf("got unconvertible type '%s'", val.Type()))
 	}
 
 	return nil
 }
 
 // transdecodeStruct
-func (t *transdecoder) transdecodeStruct(name string, val reflect.Value) error {
-	valInterface := reflect.Indirect(val)
-	valType := valInterface.Type()
+func (t *transdecoder) transdecodeStruct(ctx context.Context, name string, val reflect.Value) error {
+	if t.opts.ZeroFields {
+		val.Set(reflect.Zero(val.Type()))
+	}
+
+	if h, ok := addrInterface(val).(BeforeTransdecoder); ok && !promotedHook(val.Type(), "BeforeTransdecode", t.opts.TagName) {
+		if err := h.BeforeTransdecode(); err != nil {
+			return newError(t.fullKey(name), val.Type(), nil, err)
+		}
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	// create an errgroup to trace the latest error and return
 	g, _ := errgroup.WithContext(ctx)
 
+	// all errors of the fields, if they are collected
+	errs := new(errorList)
+
 	// The slice will keep track of all structs we'll be transcoding.
 	// There can be more structs, if we have embedded structs that are squashed.
 	structs := make([]reflect.Value, 1, 5)
@@ -248,37 +722,62 @@ func (t *transdecoder) transdecodeStruct(name string, val reflect.Value) error {
 	type field struct {
 		field reflect.StructField
 		val   reflect.Value
-		json  bool
+		enc   string
+		tag   string
+		opts  tagOptions
 	}
 	fields := []field{}
 	for len(structs) > 0 { // could be easier
 		structVal := structs[0]
 		structs = structs[1:]
-		// here we should do squashing
-
-		for i := 0; i < valType.NumField(); i++ {
-			fieldType := valType.Field(i)
-			// json is somehow special
-			// it is curated by golang json
-			isJSON := false
-			// fieldKind := fieldType.Type.Kind()
-
-			// detected if this field is json
-			if fieldType.Tag.Get("json") != "" {
-				isJSON = true
+		structType := structVal.Type()
+
+		for i := 0; i < structType.NumField(); i++ {
+			fieldType := structType.Field(i)
+			fieldVal := structVal.Field(i)
+
+			tag, opts := parseTag(fieldType.Tag.Get(t.opts.TagName))
+			if skipField(fieldType, tag, opts) {
+				continue
+			}
+
+			// the fields of squashed structs are transdecoded as fields of this struct
+			if opts.squash() {
+				if fieldVal.Kind() == reflect.Ptr && fieldVal.Type().Elem().Kind() == reflect.Struct {
+					if fieldVal.IsNil() {
+						// unexported embedded pointers can not be allocated
+						if !fieldVal.CanSet() {
+							continue
+						}
+						fieldVal.Set(reflect.New(fieldVal.Type().Elem()))
+					}
+					fieldVal = fieldVal.Elem()
+				}
+
+				if fieldVal.Kind() == reflect.Struct {
+					structs = append(structs, fieldVal)
+					continue
+				}
+			}
+
+			// fields with a json tag are json, if the json tags are used
+			enc := opts.encoding()
+			if jsonTag := fieldType.Tag.Get("json"); enc == "" && tag == "" && jsonTag != "" && t.opts.JSONTags {
+				if jsonTag == "-" {
+					continue
+				}
+				enc = "json"
 			}
 
-			fields = append(fields, field{fieldType, structVal.Field(i), isJSON})
+			fields = append(fields, field{fieldType, fieldVal, enc, tag, opts})
 		}
 	}
 
 	for _, f := range fields {
 		f := f
-		field, val, isJSON := f.field, f.val, f.json
+		field, val, enc, tag := f.field, f.val, f.enc, f.tag
 		kv := strings.ToLower(field.Name)
 
-		tag := field.Tag.Get(t.opts.TagName)
-		tag = strings.SplitN(tag, ",", 2)[0]
 		if tag != "" {
 			kv = tag
 		}
@@ -291,90 +790,516 @@ func (t *transdecoder) transdecodeStruct(name string, val reflect.Value) error {
 			continue
 		}
 
-		// we deal with
-		if isJSON && tag == "" {
-			if !val.CanAddr() {
-				continue
-			}
+		// missing keys are tolerated if the field can be omitted
+		omitEmpty := f.opts.Has("omitempty")
 
-			// check if we have to omit
-			tag := field.Tag.Get("json")
-			if tag == "-" {
-				continue
-			}
+		// the default is used if there is nothing stored at the key
+		def, hasDefault := f.opts.defaultValue()
+		if !hasDefault {
+			def, hasDefault = field.Tag.Lookup("default")
+		}
 
-			g.Go(func() error {
-				// if there is no kvPair
-				kvPair, err := t.getKVPair(kv, nil)
-				if err != nil {
-					return fmt.Errorf("'%s' field got : %s", field.Name, err)
-				}
+		// something has to be stored at the key of required fields
+		required := f.opts.Has("required")
+
+		g.Go(func() error {
+			var kvPair *store.KVPair
 
-				obj := reflect.New(field.Type).Interface()
-				if err := json.Unmarshal(kvPair.Value, &obj); err != nil {
-					return fmt.Errorf("'%s' field got : %s", field.Name, err)
+			if hasDefault || required || omitEmpty {
+				var ok bool
+				var err error
+
+				kvPair, ok, err = t.lookup(ctx, kv)
+				if err != nil {
+					return t.fieldError(errs, field.Name, err)
 				}
 
-				if obj == nil {
+				switch {
+				case !ok && hasDefault:
+					return t.fieldError(errs, field.Name, withType(t.transdecodeDefault(ctx, kv, val, enc, def), field.Type))
+				case !ok && required:
+					return t.fieldError(errs, field.Name, newError(t.fullKey(kv), field.Type, nil, ErrRequired))
+				case !ok:
+					// nothing is stored for the omitted field
 					return nil
 				}
+			}
 
-				val.Set(reflect.ValueOf(obj).Elem())
+			var err error
+			if enc != "" {
+				// encoded fields are read from a single key
+				err = withType(t.transdecodeEncoded(ctx, kv, val, enc, kvPair), field.Type)
+				if err == nil {
+					err = t.validate(kv, val, false)
+				}
+			} else {
+				err = t.transdecode(ctx, kv, val, kvPair)
+			}
 
-				return nil
-			})
+			return t.fieldError(errs, field.Name, err)
+		})
+	}
 
-			continue
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	if err := errs.err(); err != nil {
+		return err
+	}
+
+	if h, ok := addrInterface(val).(AfterTransdecoder); ok && !promotedHook(val.Type(), "AfterTransdecode", t.opts.TagName) {
+		if err := h.AfterTransdecode(); err != nil {
+			return newError(t.fullKey(name), val.Type(), nil, err)
 		}
+	}
 
-		g.Go(func() error {
-			if err := t.transdecode(kv, val, nil); err != nil {
+	// the struct is validated after all its fields
+	return t.validate(name, val, true)
+}
+
+// validate calls the Validate method and the validate function of a struct.
+// Other values are not validated. If the fields of the struct have been
+// transdecoded, a Validate method promoted from an embedded struct has been
+// called on the embedded struct already.
+func (t *transdecoder) validate(name string, val reflect.Value, fields bool) error {
+	if val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return nil
+		}
+		val = val.Elem()
+	}
+
+	if val.Kind() != reflect.Struct {
+		return nil
+	}
+
+	v := addrInterface(val)
+	if v == nil {
+		return nil
+	}
+
+	if validator, ok := v.(Validator); ok && !(fields && promotedHook(val.Type(), "Validate", t.opts.TagName)) {
+		if err := validator.Validate(); err != nil {
+			return newError(t.fullKey(name), val.Type(), nil, err)
+		}
+	}
+
+	if t.opts.Validate != nil {
+		if err := t.opts.Validate(v); err != nil {
+			return newError(t.fullKey(name), val.Type(), nil, err)
+		}
+	}
+
+	return nil
+}
+
+// transdecodeEncoded decodes the value of a field with the encoding.
+// The elements of csv encoded values are decoded as the values of keys.
+func (t *transdecoder) transdecodeEncoded(ctx context.Context, name string, val reflect.Value, enc string, kvPair *store.KVPair) error {
+	kvPair, err := t.getKVPair(ctx, name, kvPair)
+	if err != nil {
+		return err
+	}
+
+	record, err := decodeValue(enc, kvPair.Value, val)
+	if err != nil {
+		return newError(t.fullKey(name), val.Type(), kvPair.Value, err)
+	}
+
+	if record == nil {
+		return nil
+	}
+
+	return t.transdecodeRecord(ctx, name, val, kvPair, record, false)
+}
+
+// transdecodeRecord decodes the elements of a record into a slice or array.
+// The elements are read from the key itself, or from their index below it.
+func (t *transdecoder) transdecodeRecord(ctx context.Context, name string, val reflect.Value, kvPair *store.KVPair, record []string, indexed bool) error {
+	if val.Kind() == reflect.Slice {
+		val.Set(reflect.MakeSlice(val.Type(), len(record), len(record)))
+	} else if len(record) > val.Len() {
+		return newError(t.fullKey(name), val.Type(), kvPair.Value, fmt.Errorf("index %d out of range", len(record)-1))
+	}
+
+	elems := make(map[int]*sliceElement, len(record))
+	for i, s := range record {
+		key := name
+		if indexed {
+			key = strings.Join([]string{name, strconv.Itoa(i)}, "/")
+		}
+
+		elems[i] = &sliceElement{name: key, kvPair: &store.KVPair{Key: t.fullKey(key), Value: []byte(s), LastIndex: kvPair.LastIndex}}
+	}
+
+	return t.transdecodeElements(ctx, name, val, elems, len(record))
+}
+
+// transdecodeDefault decodes the default value of a field, as if it was stored
+// at the key. Slices and arrays which are not encoded are comma separated.
+func (t *transdecoder) transdecodeDefault(ctx context.Context, name string, val reflect.Value, enc string, def string) error {
+	kvPair := &store.KVPair{Key: t.fullKey(name), Value: []byte(def)}
+
+	if val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			val.Set(reflect.New(val.Type().Elem()))
+		}
+		val = val.Elem()
+	}
+
+	var record []string
+	var err error
+
+	switch {
+	case enc != "":
+		err = t.transdecodeEncoded(ctx, name, val, enc, kvPair)
+	case t.isSingleKey(val):
+		err = t.transdecode(ctx, name, val, kvPair)
+	case val.Kind() == reflect.Slice || val.Kind() == reflect.Array:
+		// the elements are decoded at their index, where they are written
+		record, err = decodeValue("csv", kvPair.Value, val)
+		if err != nil {
+			err = newError(t.fullKey(name), val.Type(), kvPair.Value, err)
+		} else if record != nil {
+			err = t.transdecodeRecord(ctx, name, val, kvPair, record, true)
+		}
+	default:
+		err = newError(t.fullKey(name), val.Type(), kvPair.Value, fmt.Errorf("default of unsupported type '%s'", val.Type()))
+	}
+
+	if err != nil || !t.opts.WriteDefaults {
+		return err
+	}
+
+	// the elements of slices and arrays are written at their index
+	if enc == "" && !t.isSingleKey(val) {
+		for i, s := range record {
+			if err := t.writeDefault(ctx, strings.Join([]string{name, strconv.Itoa(i)}, "/"), []byte(s)); err != nil {
 				return err
 			}
+		}
 
-			return nil
-		})
+		return nil
 	}
 
-	if err := g.Wait(); err != nil {
+	return t.writeDefault(ctx, name, kvPair.Value)
+}
+
+// writeDefault writes the default value at the key
+func (t *transdecoder) writeDefault(ctx context.Context, key string, value []byte) error {
+	if err := contextError(ctx, t.fullKey(key)); err != nil {
 		return err
 	}
 
+	if err := t.opts.KV.Put(t.fullKey(key), value, nil); err != nil {
+		return newError(t.fullKey(key), nil, value, err)
+	}
+
 	return nil
 }
 
-// transdecodeSlice
-func (t *transdecoder) transdecodeSlice(name string, val reflect.Value) error {
-	kvPairs, err := t.listKVPairs(name)
+// fieldError adds the field to the path of the error. The error is
+// returned, or added to the list if all errors are collected.
+func (t *transdecoder) fieldError(errs *errorList, field string, err error) error {
+	err = withField(err, field)
+
+	if t.opts.CollectErrors || t.opts.Strict {
+		errs.add(err)
+		return nil
+	}
+
+	return err
+}
+
+// maxSliceHoles is the number of indexes a slice may be missing, so that
+// a stray key with a huge index does not allocate a huge slice
+const maxSliceHoles = 1024
+
+// sliceElement is an element of a slice in the kv
+type sliceElement struct {
+	// name is the key of the element
+	name string
+
+	// kvPair is set if the element is a leaf
+	kvPair *store.KVPair
+}
+
+// sliceElements returns the elements below the key by their index,
+// and the length of the slice. The last path segment of the elements
+// is parsed as the index, and nested keys are grouped per element.
+func (t *transdecoder) sliceElements(name string, kvPairs []*store.KVPair) (map[int]*sliceElement, int, error) {
+	elems := make(map[int]*sliceElement)
+	length := 0
+
+	for _, c := range children(t.fullKey(name), kvPairs) {
+		i, err := strconv.Atoi(c.name)
+		if err != nil || i < 0 {
+			if t.opts.Strict {
+				return nil, 0, newError(trailingSlash(t.fullKey(name))+c.name, nil, nil, fmt.Errorf("'%s' is not an index", c.name))
+			}
+
+			continue
+		}
+
+		if _, ok := elems[i]; ok {
+			return nil, 0, newError(trailingSlash(t.fullKey(name))+c.name, nil, nil, fmt.Errorf("duplicate index %d", i))
+		}
+
+		elems[i] = &sliceElement{name: strings.Join([]string{name, c.name}, "/"), kvPair: c.kvPair}
+
+		if i >= length {
+			length = i + 1
+		}
+	}
+
+	if t.opts.Strict && len(elems) < length {
+		for i := 0; i < length; i++ {
+			if _, ok := elems[i]; !ok {
+				return nil, 0, newError(t.fullKey(name), nil, nil, fmt.Errorf("missing index %d", i))
+			}
+		}
+	}
+
+	return elems, length, nil
+}
+
+// transdecodeSlice decodes the elements by their index,
+// the slice is grown to the highest index
+func (t *transdecoder) transdecodeSlice(ctx context.Context, name string, val reflect.Value) error {
+	kvPairs, err := t.listKVPairs(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	elems, length, err := t.sliceElements(name, kvPairs)
 	if err != nil {
 		return err
 	}
 
-	s := reflect.MakeSlice(val.Type(), len(kvPairs), len(kvPairs))
+	if length == 0 {
+		var done bool
+
+		elems, length, done, err = t.singleElements(ctx, name, val)
+		if err != nil || done {
+			return err
+		}
+	}
+
+	if length-len(elems) > maxSliceHoles {
+		return newError(t.fullKey(elems[length-1].name), val.Type(), nil, fmt.Errorf("index %d out of range", length-1))
+	}
+
+	// the slice is reused and grown as needed, unless it should be zeroed
+	s := val
+	if s.IsNil() || t.opts.ZeroFields {
+		s = reflect.MakeSlice(val.Type(), length, length)
+	} else if s.Len() < length {
+		s = reflect.AppendSlice(s, reflect.MakeSlice(val.Type(), length-s.Len(), length-s.Len()))
+	}
 	val.Set(s)
 
-	// todo: this can be more efficient, because this is costly
-	for i, v := range kvPairs {
-		kind := getKind(val.Index(i))
-		switch kind {
-		case reflect.Ptr:
-			val.Index(i).Set(reflect.New(val.Index(i).Type().Elem()))
-			t.transdecode(strings.Replace(v.Key, trailingSlash(t.opts.Prefix), "", -1), val.Index(i).Elem(), nil)
-		case reflect.String:
-			fallthrough
-		case reflect.Bool:
-			fallthrough
-		case reflect.Int:
-			fallthrough
-		case reflect.Uint:
-			fallthrough
-		case reflect.Float32:
-			fallthrough
-		case reflect.Slice:
-			t.transdecode(strings.Replace(v.Key, trailingSlash(t.opts.Prefix), "", -1), val.Index(i), v)
+	return t.transdecodeElements(ctx, name, val, elems, length)
+}
+
+// transdecodeArray decodes the elements by their index,
+// the index must be within the length of the array
+func (t *transdecoder) transdecodeArray(ctx context.Context, name string, val reflect.Value) error {
+	kvPairs, err := t.listKVPairs(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	elems, length, err := t.sliceElements(name, kvPairs)
+	if err != nil {
+		return err
+	}
+
+	if length == 0 {
+		var done bool
+
+		elems, length, done, err = t.singleElements(ctx, name, val)
+		if err != nil || done {
+			return err
+		}
+	}
+
+	if length > val.Len() {
+		return newError(t.fullKey(name), val.Type(), nil, fmt.Errorf("index %d out of range", length-1))
+	}
+
+	if t.opts.ZeroFields {
+		val.Set(reflect.Zero(val.Type()))
+	}
+
+	return t.transdecodeElements(ctx, name, val, elems, length)
+}
+
+// singleElements returns the elements of a slice or an array which is
+// stored at a single key. The value is either split by the decode hook,
+// or decoded as the only element with weakly typed input.
+// It returns true if the value has been set by the decode hook.
+func (t *transdecoder) singleElements(ctx context.Context, name string, val reflect.Value) (map[int]*sliceElement, int, bool, error) {
+	elems := make(map[int]*sliceElement)
+
+	if t.opts.DecodeHook == nil && !t.opts.WeaklyTypedInput {
+		return elems, 0, false, nil
+	}
+
+	kvPair, err := t.getKVPair(ctx, name, nil)
+	if errors.Is(err, store.ErrKeyNotFound) {
+		return elems, 0, false, nil
+	}
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	if t.opts.DecodeHook != nil {
+		v, err := t.opts.DecodeHook(kvPair.Value, val.Type())
+		if err != nil {
+			return nil, 0, false, newError(t.fullKey(name), val.Type(), kvPair.Value, err)
+		}
+
+		switch v := v.(type) {
+		case nil:
+		case []string:
+			for i, s := range v {
+				elems[i] = &sliceElement{name: name, kvPair: &store.KVPair{Key: kvPair.Key, Value: []byte(s), LastIndex: kvPair.LastIndex}}
+			}
+
+			return elems, len(v), false, nil
+		case []byte, string:
+			raw, _ := rawValue(v)
+			kvPair = &store.KVPair{Key: kvPair.Key, Value: raw, LastIndex: kvPair.LastIndex}
 		default:
-			return fmt.Errorf("'%s' got unconvertible type '%s'", name, val.Type())
+			if err := setHookValue(val, v); err != nil {
+				return nil, 0, false, newError(t.fullKey(name), val.Type(), kvPair.Value, err)
+			}
+
+			return elems, 0, true, nil
+		}
+	}
+
+	if !t.opts.WeaklyTypedInput {
+		return elems, 0, false, nil
+	}
+
+	elems[0] = &sliceElement{name: name, kvPair: kvPair}
+
+	return elems, 1, false, nil
+}
+
+// transdecodeElements decodes the elements of a slice or an array,
+// the elements at missing indexes are left as they are
+func (t *transdecoder) transdecodeElements(ctx context.Context, name string, val reflect.Value, elems map[int]*sliceElement, length int) error {
+	errs := new(errorList)
+	failed := &SliceError{Key: t.fullKey(name)}
+
+	for i := 0; i < length; i++ {
+		e, ok := elems[i]
+		if !ok {
+			continue
+		}
+
+		elem := val.Index(i)
+
+		// the element exists, so a pointer is always allocated
+		if elem.Kind() == reflect.Ptr {
+			if elem.IsNil() {
+				elem.Set(reflect.New(elem.Type().Elem()))
+			}
+			elem = elem.Elem()
+		}
+
+		err := t.transdecode(ctx, e.name, elem, e.kvPair)
+		if err == nil {
+			continue
+		}
+
+		// the failed element is skipped, and the others are kept
+		if t.opts.PartialSlices {
+			failed.Indices = append(failed.Indices, i)
+			failed.Errors = append(failed.Errors, withField(err, "["+strconv.Itoa(i)+"]"))
+			continue
+		}
+
+		if err := t.fieldError(errs, "["+strconv.Itoa(i)+"]", err); err != nil {
+			return err
+		}
+	}
+
+	if len(failed.Indices) > 0 {
+		return failed
+	}
+
+	return errs.err()
+}
+
+// transdecodeMap
+func (t *transdecoder) transdecodeMap(ctx context.Context, name string, val reflect.Value) error {
+	kvPairs, err := t.listKVPairs(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	// the map is merged, unless it should be zeroed
+	valType := val.Type()
+	if val.IsNil() || t.opts.ZeroFields {
+		val.Set(reflect.MakeMap(valType))
+	}
+
+	errs := new(errorList)
+
+	for _, c := range children(trailingSlash(t.opts.Prefix)+name, kvPairs) {
+		k := reflect.New(valType.Key()).Elem()
+		if err := transdecodeMapKey(c.name, k); err != nil {
+			if err := t.fieldError(errs, "["+c.name+"]", newError(t.fullKey(name+"/"+c.name), valType.Key(), nil, err)); err != nil {
+				return err
+			}
+			continue
 		}
+
+		v := reflect.New(valType.Elem()).Elem()
+		if e := val.MapIndex(k); e.IsValid() {
+			v.Set(e)
+		}
+
+		if err := t.transdecode(ctx, strings.Join([]string{name, c.name}, "/"), v, c.kvPair); err != nil {
+			if err := t.fieldError(errs, "["+c.name+"]", err); err != nil {
+				return err
+			}
+			continue
+		}
+
+		val.SetMapIndex(k, v)
+	}
+
+	return errs.err()
+}
+
+// transdecodeMapKey sets the map key from its string representation
+func transdecodeMapKey(s string, k reflect.Value) error {
+	if u, ok := k.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+
+	switch getKind(k) {
+	case reflect.String:
+		k.SetString(s)
+	case reflect.Int:
+		conv, err := strconv.ParseInt(s, 10, k.Type().Bits())
+		if err != nil {
+			return err
+		}
+		k.SetInt(conv)
+	case reflect.Uint:
+		conv, err := strconv.ParseUint(s, 10, k.Type().Bits())
+		if err != nil {
+			return err
+		}
+		k.SetUint(conv)
+	default:
+		return fmt.Errorf("unsupported map key type '%s'", k.Type())
 	}
 
 	return nil
@@ -387,28 +1312,110 @@ func like(like interface{}) interface{} {
 	return one.Interface()
 }
 
+// fullKey returns the key in the kv
+func (t *transdecoder) fullKey(key string) string {
+	return trailingSlash(t.opts.Prefix) + key
+}
+
 // getKVPair
-func (t *transdecoder) getKVPair(key string, kvPair *store.KVPair) (*store.KVPair, error) {
+func (t *transdecoder) getKVPair(ctx context.Context, key string, kvPair *store.KVPair) (*store.KVPair, error) {
 	var err error
 
-	if kvPair != nil {
-		return kvPair, nil
+	if kvPair == nil {
+		fullKey := trailingSlash(t.opts.Prefix) + key
+
+		if t.snapshot != nil && t.snapshot.covers(fullKey) {
+			kvPair, err = t.snapshot.get(fullKey)
+		} else if err = contextError(ctx, fullKey); err == nil {
+			kvPair, err = t.opts.KV.Get(fullKey)
+		}
+
+		if err != nil {
+			return nil, newError(fullKey, nil, nil, err)
+		}
 	}
 
-	kvPair, err = t.opts.KV.Get(trailingSlash(t.opts.Prefix) + key)
-	if err != nil {
-		return nil, err
+	// keys which are passed down after they have been read are only tracked once
+	if t.keys.add(key) && t.opts.Metadata != nil {
+		t.mu.Lock()
+		t.opts.Metadata.Keys = append(t.opts.Metadata.Keys, key)
+		t.mu.Unlock()
 	}
 
 	return kvPair, nil
 }
 
-func (t *transdecoder) listKVPairs(key string) ([]*store.KVPair, error) {
-	kvPairs, err := t.opts.KV.List(trailingSlash(t.opts.Prefix) + key)
+// isLeaf returns true if the values of the type are read from a single key,
+// regardless of their kind
+func (t *transdecoder) isLeaf(typ reflect.Type) bool {
+	if _, ok := t.opts.Codecs[typ]; ok {
+		return true
+	}
+
+	return isText(typ)
+}
+
+// lookup returns the pair at the key, if there is one,
+// and whether there is anything stored at the key or below it
+func (t *transdecoder) lookup(ctx context.Context, key string) (*store.KVPair, bool, error) {
+	kvPair, err := t.getKVPair(ctx, key, nil)
+	if err == nil {
+		return kvPair, true, nil
+	}
+
+	if !errors.Is(err, store.ErrKeyNotFound) {
+		return nil, false, err
+	}
+
+	ok, err := t.hasChildren(ctx, key)
+
+	return nil, ok, err
+}
+
+// isSingleKey returns true if the value is read from a single key
+func (t *transdecoder) isSingleKey(val reflect.Value) bool {
+	if unmarshalsKV(val.Type()) {
+		return false
+	}
+
+	switch getKind(val) {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Uint, reflect.Float32:
+		return true
+	}
+
+	return t.isLeaf(val.Type())
+}
+
+// hasChildren returns true if there are any keys below the key
+func (t *transdecoder) hasChildren(ctx context.Context, key string) (bool, error) {
+	kvPairs, err := t.listKVPairs(ctx, key)
 	if err != nil {
+		return false, err
+	}
+
+	return len(children(trailingSlash(t.opts.Prefix)+key, kvPairs)) > 0, nil
+}
+
+func (t *transdecoder) listKVPairs(ctx context.Context, key string) ([]*store.KVPair, error) {
+	fullKey := trailingSlash(t.opts.Prefix) + key
+
+	if t.snapshot != nil && t.snapshot.covers(trailingSlash(fullKey)) {
+		return t.snapshot.list(fullKey), nil
+	}
+
+	if err := contextError(ctx, fullKey); err != nil {
 		return nil, err
 	}
 
+	kvPairs, err := t.opts.KV.List(fullKey)
+	if err == store.ErrKeyNotFound {
+		return []*store.KVPair{}, nil
+	}
+
+	if err != nil {
+		return nil, newError(fullKey, nil, nil, err)
+	}
+
 	return kvPairs, nil
 }
 
@@ -448,5 +1455,9 @@ func configureTransdecoder(t *transdecoder, opts ...TransdecoderOpt) error {
 		t.opts.TagName = defaultTagName
 	}
 
+	if t.opts.TimeLayout == "" {
+		t.opts.TimeLayout = defaultTimeLayout
+	}
+
 	return nil
 }
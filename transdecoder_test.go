@@ -1,10 +1,18 @@
 package kvstructure_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	. "github.com/andersnormal/kvstructure"
+	"github.com/andersnormal/kvstructure/memstore"
 	mm "github.com/andersnormal/kvstructure/mock"
 
 	"github.com/docker/libkv/store"
@@ -53,7 +61,7 @@ func TestTransdecodeStruct(t *testing.T) {
 	s.On("Get", "prefix/foo/tests/0/proto").Return(
 		&store.KVPair{
 			Key:   "prefix/foo/tests/0/proto",
-			Value: []byte("bar"),
+			Value: []byte("\"\""),
 		},
 		nil,
 	)
@@ -74,7 +82,7 @@ func TestTransdecodeStruct(t *testing.T) {
 	s.On("Get", "prefix/foo/tests/0/withomit").Return(
 		&store.KVPair{
 			Key:   "prefix/foo/tests/0/withomit",
-			Value: []byte{},
+			Value: []byte("\"\""),
 		},
 		nil,
 	)
@@ -88,6 +96,7 @@ func TestTransdecodeStruct(t *testing.T) {
 	td, err := NewTransdecoder(
 		TransdecoderWithKV(kv),
 		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithJSONTags(),
 	)
 
 	tt := new(Test)
@@ -154,6 +163,40 @@ func TestTransdecodeInt(t *testing.T) {
 	assert.Equal(t, 999, tt)
 }
 
+func TestTransdecodeIntBitSize(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("Get", "prefix/foo").Return(
+		&store.KVPair{
+			Key:   "prefix/foo",
+			Value: []byte(fmt.Sprint(300)),
+		},
+		nil,
+	)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+
+	var i16 int16
+	var i8 int8
+	var u8 uint8
+
+	assert.NoError(t, err)
+
+	err = td.Transdecode("foo", &i16)
+	assert.NoError(t, err)
+	assert.Equal(t, int16(300), i16)
+
+	err = td.Transdecode("foo", &i8)
+	assert.Error(t, err)
+
+	err = td.Transdecode("foo", &u8)
+	assert.Error(t, err)
+}
+
 func TestTransdecodeFloat32(t *testing.T) {
 	s := &mm.Mock{}
 	s.On("Get", "prefix/foo").Return(
@@ -227,3 +270,1876 @@ func TestTransdecodeSlice(t *testing.T) {
 	assert.NoError(t, err)
 	assert.ElementsMatch(t, []string{"foo", "bar"}, tt)
 }
+
+// Addr is a comparable IPv4 address that is used as map key
+type Addr [4]byte
+
+func (a Addr) MarshalText() ([]byte, error) {
+	return []byte(net.IP(a[:]).String()), nil
+}
+
+func (a *Addr) UnmarshalText(b []byte) error {
+	ip := net.ParseIP(string(b)).To4()
+	if ip == nil {
+		return fmt.Errorf("invalid address '%s'", b)
+	}
+	copy(a[:], ip)
+
+	return nil
+}
+
+func TestTransdecodeMap(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("List", "prefix/foo").Return(
+		[]*store.KVPair{
+			&store.KVPair{
+				Key:   "prefix/foo/a/host",
+				Value: []byte("localhost"),
+			},
+			&store.KVPair{
+				Key:   "prefix/foo/a/port",
+				Value: []byte(fmt.Sprint(80)),
+			},
+		},
+		nil,
+	)
+	s.On("Get", "prefix/foo/a/host").Return(
+		&store.KVPair{
+			Key:   "prefix/foo/a/host",
+			Value: []byte("localhost"),
+		},
+		nil,
+	)
+	s.On("Get", "prefix/foo/a/port").Return(
+		&store.KVPair{
+			Key:   "prefix/foo/a/port",
+			Value: []byte(fmt.Sprint(80)),
+		},
+		nil,
+	)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+
+	var tt map[string]Backend
+
+	assert.NoError(t, err)
+
+	err = td.Transdecode("foo", &tt)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]Backend{"a": Backend{Host: "localhost", Port: 80}}, tt)
+}
+
+func TestTransdecodeMapKeyOverflow(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/300", []byte("x"), nil))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	var tt map[int8]string
+
+	err = td.Transdecode("foo", &tt)
+	assert.True(t, errors.Is(err, strconv.ErrRange))
+}
+
+func TestTransdecodeMapSlice(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("List", "prefix/foo").Return(
+		[]*store.KVPair{
+			&store.KVPair{
+				Key:   "prefix/foo/a/0",
+				Value: []byte("foo"),
+			},
+		},
+		nil,
+	)
+	s.On("List", "prefix/foo/a").Return(
+		[]*store.KVPair{
+			&store.KVPair{
+				Key:   "prefix/foo/a/0",
+				Value: []byte("foo"),
+			},
+		},
+		nil,
+	)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+
+	var tt map[string][]string
+
+	assert.NoError(t, err)
+
+	err = td.Transdecode("foo", &tt)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"a": []string{"foo"}}, tt)
+}
+
+func TestTransdecodeMapTextUnmarshaler(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("List", "prefix/foo").Return(
+		[]*store.KVPair{
+			&store.KVPair{
+				Key:   "prefix/foo/127.0.0.1",
+				Value: []byte("localhost"),
+			},
+		},
+		nil,
+	)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+
+	var tt map[Addr]string
+
+	assert.NoError(t, err)
+
+	err = td.Transdecode("foo", &tt)
+	assert.NoError(t, err)
+	assert.Equal(t, map[Addr]string{Addr{127, 0, 0, 1}: "localhost"}, tt)
+}
+
+func TestTransdecodePointers(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("Get", "prefix/foo/name").Return(
+		&store.KVPair{
+			Key:   "prefix/foo/name",
+			Value: []byte("bar"),
+		},
+		nil,
+	)
+	s.On("List", "prefix/foo/backend").Return(
+		[]*store.KVPair{
+			&store.KVPair{
+				Key:   "prefix/foo/backend/host",
+				Value: []byte("localhost"),
+			},
+			&store.KVPair{
+				Key:   "prefix/foo/backend/port",
+				Value: []byte(fmt.Sprint(80)),
+			},
+		},
+		nil,
+	)
+	s.On("Get", "prefix/foo/backend/host").Return(
+		&store.KVPair{
+			Key:   "prefix/foo/backend/host",
+			Value: []byte("localhost"),
+		},
+		nil,
+	)
+	s.On("Get", "prefix/foo/backend/port").Return(
+		&store.KVPair{
+			Key:   "prefix/foo/backend/port",
+			Value: []byte(fmt.Sprint(80)),
+		},
+		nil,
+	)
+	s.On("Get", "prefix/foo/value").Return((*store.KVPair)(nil), store.ErrKeyNotFound)
+	s.On("List", "prefix/foo/value").Return(
+		[]*store.KVPair{
+			&store.KVPair{
+				Key:   "prefix/foo/value/a",
+				Value: []byte("b"),
+			},
+			&store.KVPair{
+				Key:   "prefix/foo/value/c/0",
+				Value: []byte("d"),
+			},
+			&store.KVPair{
+				Key:   "prefix/foo/value/c/1",
+				Value: []byte("e"),
+			},
+		},
+		nil,
+	)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+
+	tt := new(Pointers)
+
+	assert.NoError(t, err)
+
+	err = td.Transdecode("foo", tt)
+	assert.NoError(t, err)
+	if assert.NotNil(t, tt.Name) {
+		assert.Equal(t, "bar", *tt.Name)
+	}
+	assert.Equal(t, &Backend{Host: "localhost", Port: 80}, tt.Backend)
+	assert.Equal(t, map[string]interface{}{"a": "b", "c": []interface{}{"d", "e"}}, tt.Value)
+}
+
+func TestTransdecodeNonEmptyInterface(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/s", []byte("bar"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/err/msg", []byte("bar"), nil))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithCollectErrors(),
+	)
+	assert.NoError(t, err)
+
+	tt := new(struct {
+		S       fmt.Stringer
+		Err     error
+		Missing fmt.Stringer
+	})
+
+	err = td.Transdecode("foo", tt)
+
+	var e *MultiError
+	if assert.True(t, errors.As(err, &e)) && assert.Len(t, e.Errors, 2) {
+		keys := make([]string, len(e.Errors))
+		for i, err := range e.Errors {
+			keys[i] = err.(*Error).Key
+			assert.Contains(t, err.Error(), "no concrete type")
+		}
+
+		assert.ElementsMatch(t, []string{"prefix/foo/s", "prefix/foo/err"}, keys)
+	}
+}
+
+func TestTransdecodeNilPointers(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("Get", "prefix/foo/name").Return((*store.KVPair)(nil), store.ErrKeyNotFound)
+	s.On("List", "prefix/foo/backend").Return(([]*store.KVPair)(nil), store.ErrKeyNotFound)
+	s.On("Get", "prefix/foo/value").Return((*store.KVPair)(nil), store.ErrKeyNotFound)
+	s.On("List", "prefix/foo/value").Return([]*store.KVPair{}, nil)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+
+	tt := new(Pointers)
+
+	assert.NoError(t, err)
+
+	err = td.Transdecode("foo", tt)
+	assert.NoError(t, err)
+	assert.Nil(t, tt.Name)
+	assert.Nil(t, tt.Backend)
+	assert.Nil(t, tt.Value)
+}
+
+func TestTransdecodeTags(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("Get", "prefix/foo/host").Return(
+		&store.KVPair{
+			Key:   "prefix/foo/host",
+			Value: []byte("localhost"),
+		},
+		nil,
+	)
+	s.On("Get", "prefix/foo/port").Return(
+		&store.KVPair{
+			Key:   "prefix/foo/port",
+			Value: []byte(fmt.Sprint(80)),
+		},
+		nil,
+	)
+	s.On("Get", "prefix/foo/name").Return((*store.KVPair)(nil), store.ErrKeyNotFound)
+	s.On("List", "prefix/foo/name").Return([]*store.KVPair{}, nil)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+
+	tt := &Tags{Skip: "skip"}
+
+	assert.NoError(t, err)
+
+	err = td.Transdecode("foo", tt)
+	assert.NoError(t, err)
+	assert.Equal(t, &Tags{
+		Embedded: Embedded{Host: "localhost"},
+		Inline:   &Backend{Host: "localhost", Port: 80},
+		Port:     80,
+		Skip:     "skip",
+	}, tt)
+}
+
+type Config struct {
+	Name     string
+	Enabled  bool
+	Port     uint16
+	Ratio    float64
+	Backends map[string]Backend
+	Tags     []string
+	Servers  []*Backend
+	Default  *Backend
+	Missing  *Backend
+}
+
+func TestTransdecodeRoundTrip(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+
+	tc, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	in := &Config{
+		Name:    "foo",
+		Enabled: true,
+		Port:    8080,
+		Ratio:   0.75,
+		Backends: map[string]Backend{
+			"a": Backend{Host: "localhost", Port: 80},
+			"b": Backend{Host: "remote", Port: 8080},
+		},
+		Tags:    []string{"foo", "bar"},
+		Servers: []*Backend{{Host: "a", Port: 1}, {Host: "b", Port: 2}},
+		Default: &Backend{Host: "default", Port: 443},
+	}
+
+	err = tc.Transcode("foo", in)
+	assert.NoError(t, err)
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	out := new(Config)
+
+	err = td.Transdecode("foo", out)
+	assert.NoError(t, err)
+	assert.Equal(t, in, out)
+}
+
+func TestTransdecodeMetadata(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/host", []byte("localhost"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/port", []byte("80"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/hots", []byte("typo"), nil))
+
+	md := new(Metadata)
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithMetadata(md),
+	)
+	assert.NoError(t, err)
+
+	tt := new(Backend)
+
+	err = td.Transdecode("foo", tt)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"foo/host", "foo/port"}, md.Keys)
+	assert.ElementsMatch(t, []string{"foo/hots"}, md.Unused)
+}
+
+func TestTransdecodeMetadataPassedDown(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/host", []byte("localhost"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/port", []byte("80"), nil))
+
+	md := new(Metadata)
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithMetadata(md),
+	)
+	assert.NoError(t, err)
+
+	tt := new(struct {
+		Host string `kvstructure:"host,required"`
+		Port *int
+	})
+
+	err = td.Transdecode("foo", tt)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"foo/host", "foo/port"}, md.Keys)
+}
+
+type Weak struct {
+	Enabled  bool
+	Disabled bool
+	Count    int
+	Size     uint8
+	Ratio    float32
+	Empty    int
+	Hosts    []string
+}
+
+func TestTransdecodeWeaklyTypedInput(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/enabled", []byte("yes"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/disabled", []byte("OFF"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/count", []byte("1.0"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/size", []byte("12.7"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/ratio", []byte("true"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/empty", []byte(""), nil))
+	assert.NoError(t, kv.Put("prefix/foo/hosts", []byte("localhost"), nil))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithWeaklyTypedInput(),
+	)
+	assert.NoError(t, err)
+
+	tt := new(Weak)
+
+	err = td.Transdecode("foo", tt)
+	assert.NoError(t, err)
+	assert.Equal(t, &Weak{
+		Enabled: true,
+		Count:   1,
+		Size:    12,
+		Ratio:   1,
+		Hosts:   []string{"localhost"},
+	}, tt)
+}
+
+func TestTransdecodeWeaklyTypedInputOutOfRange(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo", []byte("256.0"), nil))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithWeaklyTypedInput(),
+	)
+	assert.NoError(t, err)
+
+	var tt uint8
+
+	err = td.Transdecode("foo", &tt)
+	assert.Error(t, err)
+}
+
+func TestTransdecodeStrictlyTypedInput(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo", []byte("yes"), nil))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	var tt bool
+
+	err = td.Transdecode("foo", &tt)
+	assert.Error(t, err)
+}
+
+func TestTransdecodeZeroFields(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/a", []byte("a"), nil))
+	assert.NoError(t, kv.Put("prefix/bar/0", []byte("a"), nil))
+
+	merge, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	zero, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithZeroFields(),
+	)
+	assert.NoError(t, err)
+
+	m := map[string]string{"b": "b"}
+	err = merge.Transdecode("foo", &m)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "a", "b": "b"}, m)
+
+	m = map[string]string{"b": "b"}
+	err = zero.Transdecode("foo", &m)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "a"}, m)
+
+	s := []string{"b", "c"}
+	err = merge.Transdecode("bar", &s)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "c"}, s)
+
+	s = []string{"b", "c"}
+	err = zero.Transdecode("bar", &s)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, s)
+}
+
+func TestTransdecodeBulk(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("List", "prefix/foo").Return(
+		[]*store.KVPair{
+			&store.KVPair{
+				Key:   "prefix/foo/name",
+				Value: []byte("foo"),
+			},
+			&store.KVPair{
+				Key:   "prefix/foo/enabled",
+				Value: []byte("true"),
+			},
+			&store.KVPair{
+				Key:   "prefix/foo/backends/a/host",
+				Value: []byte("localhost"),
+			},
+			&store.KVPair{
+				Key:   "prefix/foo/backends/a/port",
+				Value: []byte("80"),
+			},
+			&store.KVPair{
+				Key:   "prefix/foo/default/host",
+				Value: []byte("default"),
+			},
+			&store.KVPair{
+				Key:   "prefix/foo/default/port",
+				Value: []byte("443"),
+			},
+			&store.KVPair{
+				Key:   "prefix/foo/port",
+				Value: []byte("8080"),
+			},
+			&store.KVPair{
+				Key:   "prefix/foo/ratio",
+				Value: []byte("0.75"),
+			},
+			&store.KVPair{
+				Key:   "prefix/foo/tags/0",
+				Value: []byte("foo"),
+			},
+		},
+		nil,
+	).Once()
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithBulk(),
+	)
+
+	tt := new(Config)
+
+	assert.NoError(t, err)
+
+	err = td.Transdecode("foo", tt)
+	assert.NoError(t, err)
+	assert.Equal(t, &Config{
+		Name:     "foo",
+		Enabled:  true,
+		Port:     8080,
+		Ratio:    0.75,
+		Backends: map[string]Backend{"a": Backend{Host: "localhost", Port: 80}},
+		Tags:     []string{"foo"},
+		Servers:  []*Backend{},
+		Default:  &Backend{Host: "default", Port: 443},
+	}, tt)
+	s.AssertExpectations(t)
+}
+
+func TestTransdecodeBulkScalar(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo", []byte("bar"), nil))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithBulk(),
+	)
+	assert.NoError(t, err)
+
+	var tt string
+
+	err = td.Transdecode("foo", &tt)
+	assert.NoError(t, err)
+	assert.Equal(t, "bar", tt)
+}
+
+func TestTransdecodeContext(t *testing.T) {
+	s := &mm.Mock{}
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	tt := new(Backend)
+
+	err = td.TransdecodeContext(ctx, "foo", tt)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Contains(t, err.Error(), "foo/")
+	s.AssertExpectations(t)
+}
+
+func TestTransdecodeError(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/name", []byte("foo"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/backends/a/host", []byte("localhost"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/backends/a/port", []byte("abc"), nil))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	tt := new(struct {
+		Name     string
+		Backends map[string]Backend
+	})
+
+	err = td.Transdecode("foo", tt)
+
+	var e *Error
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, "prefix/foo/backends/a/port", e.Key)
+		assert.Equal(t, "Backends[a].Port", e.Field)
+		assert.Equal(t, reflect.TypeOf(0), e.Type)
+		assert.Equal(t, []byte("abc"), e.Value)
+	}
+	assert.True(t, errors.Is(err, strconv.ErrSyntax))
+}
+
+func TestTransdecodeErrorKeyNotFound(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/host", []byte("localhost"), nil))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	err = td.Transdecode("foo", new(Backend))
+	assert.True(t, errors.Is(err, store.ErrKeyNotFound))
+
+	var e *Error
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, "prefix/foo/port", e.Key)
+		assert.Equal(t, "Port", e.Field)
+	}
+}
+
+func TestTransdecodeCollectErrors(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/enabled", []byte("abc"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/port", []byte("abc"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/ratio", []byte("0.5"), nil))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithCollectErrors(),
+	)
+	assert.NoError(t, err)
+
+	tt := new(struct {
+		Name    string
+		Enabled bool
+		Port    int
+		Ratio   float64
+	})
+
+	err = td.Transdecode("foo", tt)
+
+	var e *MultiError
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Len(t, e.Errors, 3)
+	}
+	assert.True(t, errors.Is(err, store.ErrKeyNotFound))
+	assert.Equal(t, 0.5, tt.Ratio)
+}
+
+func TestTransdecodeSliceError(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/ports/0", []byte("80"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/ports/1", []byte("abc"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/ports/2", []byte("443"), nil))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	tt := new(struct {
+		Ports []int
+	})
+
+	err = td.Transdecode("foo", tt)
+	assert.True(t, errors.Is(err, strconv.ErrSyntax))
+
+	var e *Error
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, "prefix/foo/ports/1", e.Key)
+		assert.Equal(t, "Ports[1]", e.Field)
+	}
+}
+
+func TestTransdecodePartialSlices(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/ports/0", []byte("80"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/ports/1", []byte("abc"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/ports/2", []byte("443"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/ports/3", []byte("def"), nil))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithPartialSlices(),
+	)
+	assert.NoError(t, err)
+
+	tt := new(struct {
+		Ports []int
+	})
+
+	err = td.Transdecode("foo", tt)
+	assert.True(t, errors.Is(err, strconv.ErrSyntax))
+	assert.Equal(t, []int{80, 0, 443, 0}, tt.Ports)
+
+	var e *SliceError
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, "prefix/foo/ports", e.Key)
+		assert.Equal(t, []int{1, 3}, e.Indices)
+		assert.Len(t, e.Errors, 2)
+	}
+
+	var ee *Error
+	if assert.True(t, errors.As(err, &ee)) {
+		assert.Equal(t, "Ports[1]", ee.Field)
+	}
+}
+
+func TestTransdecodeSliceOrder(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	for i := 0; i < 12; i++ {
+		assert.NoError(t, kv.Put("prefix/foo/"+strconv.Itoa(i), []byte(strconv.Itoa(i)), nil))
+	}
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	var tt []int
+
+	err = td.Transdecode("foo", &tt)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}, tt)
+}
+
+func TestTransdecodeSliceIndexOutOfRange(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/items/0", []byte("a"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/items/99999999999999", []byte("z"), nil))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	tt := new(struct {
+		Items []string
+	})
+
+	err = td.Transdecode("foo", tt)
+
+	var e *Error
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, "prefix/foo/items/99999999999999", e.Key)
+		assert.Equal(t, "Items", e.Field)
+		assert.Contains(t, e.Err.Error(), "out of range")
+	}
+}
+
+func TestTransdecodeSliceSparse(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/0", []byte("a"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/2", []byte("c"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/name", []byte("foo"), nil))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	var tt []string
+
+	err = td.Transdecode("foo", &tt)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "", "c"}, tt)
+
+	strict, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithStrict(),
+	)
+	assert.NoError(t, err)
+
+	err = strict.Transdecode("foo", &tt)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not an index")
+
+	assert.NoError(t, kv.Delete("prefix/foo/name"))
+
+	err = strict.Transdecode("foo", &tt)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "missing index 1")
+}
+
+func TestTransdecodeSliceDuplicate(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/1", []byte("a"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/01", []byte("b"), nil))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	var tt []string
+
+	err = td.Transdecode("foo", &tt)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "duplicate index 1")
+}
+
+func TestTransdecodeSliceNested(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/0/host", []byte("a"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/0/port", []byte("80"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/1/host", []byte("b"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/1/port", []byte("443"), nil))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	var tt []*Backend
+
+	err = td.Transdecode("foo", &tt)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Backend{{Host: "a", Port: 80}, {Host: "b", Port: 443}}, tt)
+}
+
+func TestTransdecodeSliceElements(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+
+	tc, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	type Elements struct {
+		Backends []Backend
+		Matrix   [][]string
+		Labels   []map[string]string
+		Pair     [2]string
+		Ports    [3]*int
+	}
+
+	port := 80
+
+	in := &Elements{
+		Backends: []Backend{{Host: "a", Port: 1}, {Host: "b", Port: 2}},
+		Matrix:   [][]string{{"a", "b"}, {"c"}},
+		Labels:   []map[string]string{{"a": "b"}, {"c": "d"}},
+		Pair:     [2]string{"a", "b"},
+		Ports:    [3]*int{&port, nil, &port},
+	}
+
+	err = tc.Transcode("foo", in)
+	assert.NoError(t, err)
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	out := new(Elements)
+
+	err = td.Transdecode("foo", out)
+	assert.NoError(t, err)
+	assert.Equal(t, in, out)
+
+	assert.NoError(t, kv.Put("prefix/foo/pair/2", []byte("c"), nil))
+
+	err = td.Transdecode("foo", out)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "out of range")
+}
+
+// Marshals can only be written as text
+type Marshals struct {
+	X int
+}
+
+func (m Marshals) MarshalText() ([]byte, error) {
+	return []byte(strconv.Itoa(m.X)), nil
+}
+
+// Unmarshals can only be read from text
+type Unmarshals struct {
+	X int
+}
+
+func (u *Unmarshals) UnmarshalText(b []byte) (err error) {
+	u.X, err = strconv.Atoi(string(b))
+	return err
+}
+
+func TestTransdecodeTextOneWay(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+
+	tc, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	type OneWay struct {
+		M Marshals
+		U Unmarshals
+	}
+
+	in := &OneWay{M: Marshals{X: 1}, U: Unmarshals{X: 2}}
+
+	err = tc.Transcode("foo", in)
+	assert.NoError(t, err)
+
+	// the types are written as structs, as they can not be read back as text
+	for key, value := range map[string]string{
+		"prefix/foo/m/x": "1",
+		"prefix/foo/u/x": "2",
+	} {
+		kvPair, err := kv.Get(key)
+		if assert.NoError(t, err, key) {
+			assert.Equal(t, value, string(kvPair.Value), key)
+		}
+	}
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	out := new(OneWay)
+
+	err = td.Transdecode("foo", out)
+	assert.NoError(t, err)
+	assert.Equal(t, in, out)
+}
+
+func TestTransdecodeText(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+
+	tc, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	type Text struct {
+		Timeout time.Duration
+		Cutoff  time.Time
+		Expires *time.Time
+		IP      net.IP
+		Addr    Addr
+	}
+
+	cutoff := time.Date(2019, 3, 1, 12, 30, 0, 500, time.UTC)
+
+	in := &Text{
+		Timeout: 30 * time.Second,
+		Cutoff:  cutoff,
+		Expires: &cutoff,
+		IP:      net.ParseIP("::1"),
+		Addr:    Addr{127, 0, 0, 1},
+	}
+
+	err = tc.Transcode("foo", in)
+	assert.NoError(t, err)
+
+	for key, value := range map[string]string{
+		"prefix/foo/timeout": "30s",
+		"prefix/foo/cutoff":  "2019-03-01T12:30:00.0000005Z",
+		"prefix/foo/expires": "2019-03-01T12:30:00.0000005Z",
+		"prefix/foo/ip":      "::1",
+		"prefix/foo/addr":    "127.0.0.1",
+	} {
+		kvPair, err := kv.Get(key)
+		if assert.NoError(t, err, key) {
+			assert.Equal(t, value, string(kvPair.Value), key)
+		}
+	}
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	out := new(Text)
+
+	err = td.Transdecode("foo", out)
+	assert.NoError(t, err)
+	assert.Equal(t, in, out)
+}
+
+func TestTransdecodeTimeLayout(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/cutoff", []byte("2019-03-01"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/timeout", []byte("1000"), nil))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithTimeLayout("2006-01-02"),
+		TransdecoderWithWeaklyTypedInput(),
+	)
+	assert.NoError(t, err)
+
+	tt := new(struct {
+		Cutoff  time.Time
+		Timeout time.Duration
+	})
+
+	err = td.Transdecode("foo", tt)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC), tt.Cutoff)
+	assert.Equal(t, time.Microsecond, tt.Timeout)
+
+	td, err = NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	var cutoff time.Time
+
+	err = td.Transdecode("foo/cutoff", &cutoff)
+
+	var e *Error
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, reflect.TypeOf(time.Time{}), e.Type)
+	}
+}
+
+// Money is stored as a single key by the moneyCodec
+type Money struct {
+	Cents    int64
+	Currency string
+}
+
+type moneyCodec struct{}
+
+func (moneyCodec) Encode(v interface{}) ([]byte, error) {
+	m := v.(Money)
+	return []byte(fmt.Sprintf("%d %s", m.Cents, m.Currency)), nil
+}
+
+func (moneyCodec) Decode(b []byte, v interface{}) error {
+	m := v.(*Money)
+	_, err := fmt.Sscanf(string(b), "%d %s", &m.Cents, &m.Currency)
+
+	return err
+}
+
+func TestTransdecodeCodec(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+
+	tc, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+		TranscoderWithCodec(reflect.TypeOf(Money{}), moneyCodec{}),
+	)
+	assert.NoError(t, err)
+
+	type Order struct {
+		Total    Money
+		Discount *Money
+		Items    []Money
+	}
+
+	in := &Order{
+		Total:    Money{Cents: 1250, Currency: "EUR"},
+		Discount: &Money{Cents: 100, Currency: "EUR"},
+		Items:    []Money{{Cents: 1000, Currency: "EUR"}, {Cents: 350, Currency: "EUR"}},
+	}
+
+	err = tc.Transcode("foo", in)
+	assert.NoError(t, err)
+
+	kvPair, err := kv.Get("prefix/foo/total")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "1250 EUR", string(kvPair.Value))
+	}
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithCodec(reflect.TypeOf(Money{}), moneyCodec{}),
+	)
+	assert.NoError(t, err)
+
+	out := new(Order)
+
+	err = td.Transdecode("foo", out)
+	assert.NoError(t, err)
+	assert.Equal(t, in, out)
+
+	assert.NoError(t, kv.Put("prefix/foo/total", []byte("EUR"), nil))
+
+	err = td.Transdecode("foo", out)
+
+	var e *Error
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, "prefix/foo/total", e.Key)
+		assert.Equal(t, reflect.TypeOf(Money{}), e.Type)
+	}
+}
+
+// Legacy keeps its address at its own key, and the weight below it
+type Legacy struct {
+	Addr   string
+	Weight int
+}
+
+func (l Legacy) MarshalKV() (map[string][]byte, error) {
+	return map[string][]byte{
+		"":            []byte(l.Addr),
+		"meta/weight": []byte(strconv.Itoa(l.Weight)),
+	}, nil
+}
+
+func (l *Legacy) UnmarshalKV(kvs map[string][]byte) error {
+	weight, err := strconv.Atoi(string(kvs["meta/weight"]))
+	if err != nil {
+		return err
+	}
+
+	l.Addr = string(kvs[""])
+	l.Weight = weight
+
+	return nil
+}
+
+func TestTransdecodeKVUnmarshaler(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+
+	tc, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	type Service struct {
+		Name    string
+		Primary Legacy
+		Backup  *Legacy
+		Missing *Legacy
+	}
+
+	in := &Service{
+		Name:    "foo",
+		Primary: Legacy{Addr: "10.0.0.1:80", Weight: 3},
+		Backup:  &Legacy{Addr: "10.0.0.2:80", Weight: 1},
+	}
+
+	err = tc.Transcode("foo", in)
+	assert.NoError(t, err)
+
+	for key, value := range map[string]string{
+		"prefix/foo/primary":             "10.0.0.1:80",
+		"prefix/foo/primary/meta/weight": "3",
+		"prefix/foo/backup":              "10.0.0.2:80",
+		"prefix/foo/backup/meta/weight":  "1",
+	} {
+		kvPair, err := kv.Get(key)
+		if assert.NoError(t, err, key) {
+			assert.Equal(t, value, string(kvPair.Value), key)
+		}
+	}
+
+	md := new(Metadata)
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithMetadata(md),
+	)
+	assert.NoError(t, err)
+
+	out := new(Service)
+
+	err = td.Transdecode("foo", out)
+	assert.NoError(t, err)
+	assert.Equal(t, in, out)
+	assert.Empty(t, md.Unused)
+
+	assert.NoError(t, kv.Put("prefix/foo/primary/meta/weight", []byte("abc"), nil))
+
+	err = td.Transdecode("foo", out)
+
+	var e *Error
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, "prefix/foo/primary", e.Key)
+		assert.Equal(t, "Primary", e.Field)
+	}
+}
+
+type Encodings struct {
+	JSON   Backend                `kvstructure:"json,json"`
+	YAML   map[string]interface{} `kvstructure:"yaml,yaml"`
+	Base64 []byte                 `kvstructure:"base64,base64"`
+	Hex    string                 `kvstructure:"hex,hex"`
+	CSV    []string               `kvstructure:"csv,csv"`
+	Raw    []byte                 `kvstructure:"raw,raw"`
+}
+
+func TestTransdecodeEncodings(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+
+	tc, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	in := &Encodings{
+		JSON:   Backend{Host: "localhost", Port: 80},
+		YAML:   map[string]interface{}{"host": "localhost", "port": 80},
+		Base64: []byte{0, 1, 2},
+		Hex:    "foo",
+		CSV:    []string{"a", "b,c"},
+		Raw:    []byte("foo"),
+	}
+
+	err = tc.Transcode("foo", in)
+	assert.NoError(t, err)
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	out := new(Encodings)
+
+	err = td.Transdecode("foo", out)
+	assert.NoError(t, err)
+	assert.Equal(t, in, out)
+}
+
+func TestTransdecodeEncodingCSV(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/ports", []byte("80,443"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/pair", []byte("1.5,abc"), nil))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithCollectErrors(),
+	)
+	assert.NoError(t, err)
+
+	tt := new(struct {
+		Ports []uint16   `kvstructure:",csv"`
+		Pair  [2]float64 `kvstructure:",csv"`
+	})
+
+	err = td.Transdecode("foo", tt)
+	assert.Equal(t, []uint16{80, 443}, tt.Ports)
+	assert.Equal(t, 1.5, tt.Pair[0])
+
+	var e *Error
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, "prefix/foo/pair", e.Key)
+		assert.Equal(t, "Pair[1]", e.Field)
+	}
+}
+
+type Defaults struct {
+	Host    string        `default:"localhost"`
+	Port    int           `kvstructure:"port,default=8080"`
+	Timeout time.Duration `default:"30s"`
+	Tags    []string      `kvstructure:"tags,default=a,b"`
+	Labels  []string      `kvstructure:"labels,json,default=[\"x\"]"`
+	Ratio   *float64      `default:"0.5"`
+}
+
+func TestTransdecodeDefaults(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/host", []byte("remote"), nil))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	tt := new(Defaults)
+
+	err = td.Transdecode("foo", tt)
+	assert.NoError(t, err)
+
+	ratio := 0.5
+	assert.Equal(t, &Defaults{
+		Host:    "remote",
+		Port:    8080,
+		Timeout: 30 * time.Second,
+		Tags:    []string{"a", "b"},
+		Labels:  []string{"x"},
+		Ratio:   &ratio,
+	}, tt)
+
+	_, err = kv.Get("prefix/foo/port")
+	assert.True(t, errors.Is(err, store.ErrKeyNotFound))
+}
+
+func TestTransdecodeWriteDefaults(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/host", []byte("remote"), nil))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithWriteDefaults(),
+	)
+	assert.NoError(t, err)
+
+	tt := new(Defaults)
+
+	err = td.Transdecode("foo", tt)
+	assert.NoError(t, err)
+
+	for key, value := range map[string]string{
+		"prefix/foo/host":    "remote",
+		"prefix/foo/port":    "8080",
+		"prefix/foo/timeout": "30s",
+		"prefix/foo/tags/0":  "a",
+		"prefix/foo/tags/1":  "b",
+		"prefix/foo/labels":  `["x"]`,
+		"prefix/foo/ratio":   "0.5",
+	} {
+		kvPair, err := kv.Get(key)
+		if assert.NoError(t, err, key) {
+			assert.Equal(t, value, string(kvPair.Value), key)
+		}
+	}
+
+	// the defaults are read back from the kv
+	out := new(Defaults)
+
+	err = td.Transdecode("foo", out)
+	assert.NoError(t, err)
+	assert.Equal(t, tt, out)
+}
+
+func TestTransdecodeWriteDefaultsStrict(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+
+	md := new(Metadata)
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithMetadata(md),
+		TransdecoderWithWriteDefaults(),
+		TransdecoderWithStrict(),
+	)
+	assert.NoError(t, err)
+
+	tt := new(struct {
+		List []string `kvstructure:"list,default=a,b"`
+	})
+
+	err = td.Transdecode("foo", tt)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, tt.List)
+	assert.ElementsMatch(t, []string{"foo/list/0", "foo/list/1"}, md.Keys)
+	assert.Empty(t, md.Unused)
+}
+
+func TestTransdecodeDefaultInvalid(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	tt := new(struct {
+		Port int `default:"abc"`
+	})
+
+	err = td.Transdecode("foo", tt)
+
+	var e *Error
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, "prefix/foo/port", e.Key)
+		assert.Equal(t, "Port", e.Field)
+		assert.Equal(t, []byte("abc"), e.Value)
+	}
+}
+
+func TestTransdecodeRequired(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/name", []byte("foo"), nil))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithCollectErrors(),
+	)
+	assert.NoError(t, err)
+
+	tt := new(struct {
+		Name    string   `kvstructure:"name,required"`
+		Servers []string `kvstructure:"servers,required"`
+		Default *Backend `kvstructure:"default,required"`
+		Tags    []string
+	})
+
+	err = td.Transdecode("foo", tt)
+	assert.True(t, errors.Is(err, ErrRequired))
+	assert.Equal(t, "foo", tt.Name)
+
+	var e *MultiError
+	if assert.True(t, errors.As(err, &e)) && assert.Len(t, e.Errors, 2) {
+		fields := []string{e.Errors[0].(*Error).Field, e.Errors[1].(*Error).Field}
+		assert.ElementsMatch(t, []string{"Servers", "Default"}, fields)
+	}
+}
+
+func TestTransdecodeStrict(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/host", []byte("localhost"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/port", []byte("abc"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/hostname", []byte("localhost"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/backend/host", []byte("localhost"), nil))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithStrict(),
+	)
+	assert.NoError(t, err)
+
+	tt := new(struct {
+		Host    string
+		Port    int
+		Weight  int `kvstructure:"weight,required"`
+		Backend *Backend
+	})
+
+	err = td.Transdecode("foo", tt)
+	assert.True(t, errors.Is(err, ErrRequired))
+	assert.True(t, errors.Is(err, ErrUnknownKey))
+	assert.True(t, errors.Is(err, strconv.ErrSyntax))
+	assert.True(t, errors.Is(err, store.ErrKeyNotFound))
+
+	var e *MultiError
+	if assert.True(t, errors.As(err, &e)) {
+		keys := make([]string, len(e.Errors))
+		for i, err := range e.Errors {
+			keys[i] = err.(*Error).Key
+		}
+
+		assert.ElementsMatch(t, []string{
+			"prefix/foo/port",
+			"prefix/foo/weight",
+			"prefix/foo/backend/port",
+			"prefix/foo/hostname",
+		}, keys)
+	}
+}
+
+func TestTransdecodeStrictValid(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/host", []byte("localhost"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/port", []byte("80"), nil))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithStrict(),
+	)
+	assert.NoError(t, err)
+
+	tt := new(Backend)
+
+	err = td.Transdecode("foo", tt)
+	assert.NoError(t, err)
+	assert.Equal(t, &Backend{Host: "localhost", Port: 80}, tt)
+}
+
+// Listener validates that its port is set
+type Listener struct {
+	Addr string
+	Port int
+}
+
+func (l *Listener) Validate() error {
+	if l.Port == 0 {
+		return errors.New("port is not set")
+	}
+
+	return nil
+}
+
+func TestTransdecodeValidate(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/name", []byte("foo"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/listeners/0/addr", []byte("a"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/listeners/0/port", []byte("80"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/listeners/1/addr", []byte("b"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/listeners/1/port", []byte("0"), nil))
+
+	type Server struct {
+		Name      string
+		Listeners []Listener
+	}
+
+	var validated []string
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithValidate(func(v interface{}) error {
+			validated = append(validated, reflect.TypeOf(v).String())
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	err = td.Transdecode("foo", new(Server))
+	assert.Error(t, err)
+
+	var e *Error
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, "prefix/foo/listeners/1", e.Key)
+		assert.Equal(t, "Listeners[1]", e.Field)
+		assert.Equal(t, "port is not set", e.Err.Error())
+	}
+
+	assert.NoError(t, kv.Put("prefix/foo/listeners/1/port", []byte("443"), nil))
+
+	validated = nil
+
+	err = td.Transdecode("foo", new(Server))
+	assert.NoError(t, err)
+
+	// nested structs are validated first
+	assert.Equal(t, []string{"*kvstructure_test.Listener", "*kvstructure_test.Listener", "*kvstructure_test.Server"}, validated)
+}
+
+func TestTransdecodeValidateFunc(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/host", []byte(""), nil))
+	assert.NoError(t, kv.Put("prefix/foo/port", []byte("80"), nil))
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithValidate(func(v interface{}) error {
+			if b, ok := v.(*Backend); ok && b.Host == "" {
+				return errors.New("host is not set")
+			}
+
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	err = td.Transdecode("foo", new(Backend))
+
+	var e *Error
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, "prefix/foo", e.Key)
+		assert.Equal(t, reflect.TypeOf(Backend{}), e.Type)
+	}
+}
+
+// Endpoint normalizes its host, and derives its address
+type Endpoint struct {
+	Host string
+	Port int
+	Addr string `kvstructure:"-"`
+
+	decoding bool
+}
+
+func (e *Endpoint) BeforeTranscode() error {
+	if e.Host == "" {
+		return errors.New("host is not set")
+	}
+
+	e.Host = strings.ToLower(e.Host)
+
+	return nil
+}
+
+func (e *Endpoint) BeforeTransdecode() error {
+	e.decoding = true
+	return nil
+}
+
+func (e *Endpoint) AfterTransdecode() error {
+	if !e.decoding {
+		return errors.New("not decoding")
+	}
+
+	e.Addr = net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	e.decoding = false
+
+	return nil
+}
+
+func TestTransdecodeLifecycle(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+
+	tc, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	err = tc.Transcode("foo", &[]Endpoint{{Host: "LOCALHOST", Port: 80}})
+	assert.NoError(t, err)
+
+	kvPair, err := kv.Get("prefix/foo/0/host")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "localhost", string(kvPair.Value))
+	}
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	var out []Endpoint
+
+	err = td.Transdecode("foo", &out)
+	assert.NoError(t, err)
+	assert.Equal(t, []Endpoint{{Host: "localhost", Port: 80, Addr: "localhost:80"}}, out)
+
+	err = tc.Transcode("foo", &[]Endpoint{{Port: 80}})
+
+	var e *Error
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, "prefix/foo/0", e.Key)
+		assert.Equal(t, "[0]", e.Field)
+		assert.Equal(t, "host is not set", e.Err.Error())
+	}
+}
+
+func TestTransdecodeStrictSiblings(t *testing.T) {
+	s := &mm.Mock{}
+	s.On("Get", "prefix/foo/host").Return(&store.KVPair{Key: "prefix/foo/host", Value: []byte("localhost")}, nil)
+	s.On("Get", "prefix/foo/port").Return(&store.KVPair{Key: "prefix/foo/port", Value: []byte("80")}, nil)
+	s.On("List", "prefix/foo").Return(
+		[]*store.KVPair{
+			&store.KVPair{Key: "prefix/foo/host", Value: []byte("localhost")},
+			&store.KVPair{Key: "prefix/foo/port", Value: []byte("80")},
+			&store.KVPair{Key: "prefix/foobar/owned", Value: []byte("owned")},
+		},
+		nil,
+	)
+
+	kv, _ := mm.New(s, []string{"localhost"}, &store.Config{})
+
+	md := new(Metadata)
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithMetadata(md),
+		TransdecoderWithStrict(),
+	)
+	assert.NoError(t, err)
+
+	var tt Backend
+
+	err = td.Transdecode("foo", &tt)
+	assert.NoError(t, err)
+	assert.Equal(t, Backend{Host: "localhost", Port: 80}, tt)
+	assert.Empty(t, md.Unused)
+}
+
+func TestTransdecodeOmitEmptyNested(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/sub/a", []byte("notanint"), nil))
+
+	type Sub struct {
+		A int
+		B int
+	}
+
+	for _, opt := range []TransdecoderOpt{TransdecoderWithStrict(), TransdecoderWithCollectErrors()} {
+		td, err := NewTransdecoder(
+			TransdecoderWithKV(kv),
+			TransdecoderWithPrefix("prefix"),
+			opt,
+		)
+		assert.NoError(t, err)
+
+		tt := new(struct {
+			Sub     Sub  `kvstructure:"sub,omitempty"`
+			Missing *Sub `kvstructure:"missing,omitempty"`
+		})
+
+		err = td.Transdecode("foo", tt)
+		assert.True(t, errors.Is(err, strconv.ErrSyntax))
+		assert.True(t, errors.Is(err, store.ErrKeyNotFound))
+	}
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	tt := new(struct {
+		Sub Sub `kvstructure:"sub,omitempty"`
+	})
+
+	// the first error of the fields is returned
+	err = td.Transdecode("foo", tt)
+	assert.Error(t, err)
+}
+
+// Hooked records the calls of its hooks
+type Hooked struct {
+	Name string
+
+	calls []string
+}
+
+func (h *Hooked) BeforeTranscode() error {
+	h.calls = append(h.calls, "BeforeTranscode")
+	return nil
+}
+
+func (h *Hooked) AfterTranscode() error {
+	h.calls = append(h.calls, "AfterTranscode")
+	return nil
+}
+
+func (h *Hooked) AfterTransdecode() error {
+	h.calls = append(h.calls, "AfterTransdecode")
+	return nil
+}
+
+func (h *Hooked) Validate() error {
+	h.calls = append(h.calls, "Validate")
+	return nil
+}
+
+// Overriding has its own Validate method, besides the one of Hooked
+type Overriding struct {
+	Hooked
+
+	validated int
+}
+
+func (o *Overriding) Validate() error {
+	o.validated++
+	return nil
+}
+
+func TestTransdecodeLifecyclePromoted(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+
+	tc, err := NewTranscoder(
+		TranscoderWithKV(kv),
+		TranscoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	in := &struct{ Hooked }{Hooked: Hooked{Name: "foo"}}
+
+	err = tc.Transcode("foo", in)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"BeforeTranscode", "AfterTranscode"}, in.calls)
+
+	td, err := NewTransdecoder(
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+
+	out := new(struct{ Hooked })
+
+	err = td.Transdecode("foo", out)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", out.Name)
+	assert.Equal(t, []string{"AfterTransdecode", "Validate"}, out.calls)
+
+	overriding := new(Overriding)
+
+	err = td.Transdecode("foo", overriding)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"AfterTransdecode", "Validate"}, overriding.calls)
+	assert.Equal(t, 1, overriding.validated)
+
+	// the hooks of squashed structs are called on the parent
+	squashed := new(struct {
+		Hooked `kvstructure:",squash"`
+	})
+
+	err = td.Transdecode("foo/hooked", squashed)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", squashed.Name)
+	assert.Equal(t, []string{"AfterTransdecode", "Validate"}, squashed.calls)
+}
@@ -1,17 +1,97 @@
 package kvstructure
 
-import "github.com/docker/libkv/store"
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/docker/libkv/store"
+)
 
 // Transcoder is the interface to a transcoder
 type Transcoder interface {
 	Transcode(string, interface{}) error
+	TranscodeContext(context.Context, string, interface{}) error
+}
+
+// DiffTranscoder is the interface to a transcoder that only writes the changes.
+// The transcoders returned by NewTranscoder implement it.
+type DiffTranscoder interface {
+	Transcoder
+
+	TranscodeDiff(context.Context, string, interface{}) (*ChangeSet, error)
 }
 
 // Transdecoder is the interface to a transdecoder
 type Transdecoder interface {
 	Transdecode(string, interface{}) error
+	TransdecodeContext(context.Context, string, interface{}) error
+}
+
+// Codec encodes and decodes the values of a type, which are stored as a single key
+type Codec interface {
+	// Encode returns the raw value that is written to the kv
+	Encode(v interface{}) ([]byte, error)
+
+	// Decode sets the value that v is pointing to from the raw value in the kv
+	Decode(b []byte, v interface{}) error
+}
+
+// KVMarshaler is implemented by types that write their own keys.
+// The keys of the map are relative to the key of the value,
+// and the empty key is the key of the value itself.
+type KVMarshaler interface {
+	MarshalKV() (map[string][]byte, error)
+}
+
+// KVUnmarshaler is implemented by types that read their own keys.
+// The map contains all keys below the key of the value, relative to it,
+// and the empty key for the value at the key itself, if it exists.
+type KVUnmarshaler interface {
+	UnmarshalKV(map[string][]byte) error
+}
+
+// DecodeHookFunc is called with the raw value of a key and the type
+// of the value that it is decoded into. It returns either a raw value,
+// i.e. a []byte or a string, which is decoded instead, or a value that
+// is set as is. Slices and arrays, which are stored at a single key,
+// can be returned as a []string of raw values of the elements.
+type DecodeHookFunc func(from []byte, to reflect.Type) (interface{}, error)
+
+// BeforeTranscoder is implemented by structs that are called
+// before their fields are encoded, e.g. to normalize values
+type BeforeTranscoder interface {
+	BeforeTranscode() error
+}
+
+// AfterTranscoder is implemented by structs that are called
+// after their fields have been encoded
+type AfterTranscoder interface {
+	AfterTranscode() error
+}
+
+// BeforeTransdecoder is implemented by structs that are called
+// before their fields are decoded
+type BeforeTransdecoder interface {
+	BeforeTransdecode() error
+}
+
+// AfterTransdecoder is implemented by structs that are called
+// after their fields have been decoded, e.g. to derive fields
+type AfterTransdecoder interface {
+	AfterTransdecode() error
 }
 
+// Validator is implemented by structs that validate themselves
+// after they have been decoded
+type Validator interface {
+	Validate() error
+}
+
+// ValidateFunc validates a struct after it has been decoded.
+// It is called with a pointer to the struct.
+type ValidateFunc func(v interface{}) error
+
 // TransdecoderOpt ...
 type TransdecoderOpt func(*TransdecoderOpts)
 
@@ -20,12 +100,68 @@ type TransdecoderOpt func(*TransdecoderOpts)
 type TransdecoderOpts struct {
 	// ZeroFields, if set to true, will zero fields before writing them.
 	// For example, a map will be emptied before decoded values are put in
-	// it. If this is false, a map will be merged.
+	// it. If this is false, a map will be merged, the elements of a slice
+	// are overwritten and a struct keeps the values of fields which
+	// are not decoded.
 	ZeroFields bool
 
-	// should be done later
+	// WeaklyTypedInput, if set to true, accepts values which are not
+	// in the format that is written by the transcoder. The following
+	// conversions are done in addition to the regular decoding.
+	//
+	//   - bools accept "1", "t", "true", "y", "yes" and "on" as true,
+	//     and "", "0", "f", "false", "n", "no" and "off" as false
+	//     (case insensitive). Any other number is true if it is not zero.
+	//   - ints and uints accept floats, which are truncated towards zero,
+	//     and "true" and "false" as 1 and 0
+	//   - floats accept "true" and "false" as 1 and 0
+	//   - durations accept an integer number of nanoseconds
+	//   - numbers and bools accept the empty string as zero
+	//   - slices accept a single value, which is decoded as the only element
+	//
 	WeaklyTypedInput bool
 
+	// CollectErrors, if set to true, decodes as much as possible and
+	// returns all errors in a MultiError, instead of the first error.
+	CollectErrors bool
+
+	// WriteDefaults, if set to true, writes the default values of fields
+	// to the kv, if there is nothing stored at their key.
+	WriteDefaults bool
+
+	// JSONTags, if set to true, decodes fields with a json tag from JSON,
+	// unless they have a name in the kvstructure tag
+	JSONTags bool
+
+	// Validate, if set, is called for every decoded struct, after
+	// its Validate method, if it implements Validator
+	Validate ValidateFunc
+
+	// DecodeHook, if set, is called with the raw values before they are decoded
+	DecodeHook DecodeHookFunc
+
+	// Codecs are used to decode the values of the types, instead of the kind
+	Codecs map[reflect.Type]Codec
+
+	// TimeLayout is the layout that is used to parse a time.Time.
+	// See time.Parse for the format. This defaults to time.RFC3339Nano
+	TimeLayout string
+
+	// Strict, if set to true, returns errors for keys which do not fit the value.
+	// Keys below the name which are not decoded are unknown, slice elements
+	// must have a numeric index and no index may be missing. All errors are
+	// collected, as if CollectErrors was set.
+	Strict bool
+
+	// PartialSlices, if set to true, keeps decoding the elements of a slice
+	// if an element fails to decode. A SliceError reports the failed indexes.
+	PartialSlices bool
+
+	// Bulk, if set to true, lists all the keys below the name once
+	// and decodes all the values from this point-in-time view,
+	// instead of retrieving every value from the kv.
+	Bulk bool
+
 	// Metadata is the struct that will contain extra metadata about
 	// the decoding. If this is nil, then no metadata will be tracked.
 	Metadata *Metadata
@@ -47,6 +183,43 @@ type TranscoderOpt func(*TranscoderOpts)
 // TranscoderOpts is the configuration that is used to create a new transcoder
 // and allows customization of various aspects of decoding.
 type TranscoderOpts struct {
+	// FloatFormat is the format that is used to write floats.
+	// See strconv.FormatFloat for the available formats. This defaults to 'g'
+	FloatFormat byte
+
+	// FloatPrecision is the precision that is used to write floats.
+	// The default of -1 uses the smallest number of digits necessary
+	// to read the value back exactly.
+	FloatPrecision int
+
+	// JSONTags, if set to true, encodes fields with a json tag as JSON,
+	// unless they have a name in the kvstructure tag
+	JSONTags bool
+
+	// Codecs are used to encode the values of the types, instead of the kind
+	Codecs map[reflect.Type]Codec
+
+	// TimeLayout is the layout that is used to write a time.Time.
+	// See time.Format for the format. This defaults to time.RFC3339Nano
+	TimeLayout string
+
+	// CollectErrors, if set to true, encodes as much as possible and
+	// returns all errors in a MultiError, instead of the first error.
+	CollectErrors bool
+
+	// DeleteNil, if set to true, deletes the subtree of nil pointers
+	// and interfaces. Otherwise they are skipped and the kv is left as is.
+	DeleteNil bool
+
+	// Prune, if set to true, deletes all keys below the name which have
+	// not been written, unless they match one of the Keep patterns.
+	Prune bool
+
+	// Keep are the patterns of the keys that are never pruned, e.g. keys which
+	// are owned by other tools. The keys are relative to the prefix and
+	// a pattern also matches the keys below. See path.Match for the syntax.
+	Keep []string
+
 	// Metadata is the struct that will contain extra metadata about
 	// the decoding. If this is nil, then no metadata will be tracked.
 	Metadata *Metadata
@@ -65,11 +238,38 @@ type TranscoderOpts struct {
 // A Transdecoder takes a raw interface value and turns it into structured data
 type transdecoder struct {
 	opts *TransdecoderOpts
+
+	// mu guards the metadata, it is shared by all runs of the transdecoder
+	mu *sync.Mutex
+
+	// keys are the keys that have been read in the current run
+	keys *keySet
+
+	// snapshot is the view of the kv in the current run, if it is decoded in bulk
+	snapshot *snapshot
 }
 
 // A Transcoder takes a raw interface and puts it into a kv structure
 type transcoder struct {
 	opts *TranscoderOpts
+
+	// mu guards the metadata, it is shared by all runs of the transcoder
+	mu *sync.Mutex
+
+	// keys are the keys that have been written in the current run
+	keys *keySet
+
+	// diff collects the changes of the current run, if only changes are written
+	diff *diff
+}
+
+// ChangeSet contains the keys that have been changed by a transcoder
+type ChangeSet struct {
+	// Puts are the keys that have been written, because their value changed
+	Puts []string
+
+	// Deletes are the keys that have been deleted
+	Deletes []string
 }
 
 // Metadata contains information about decoding a structure that
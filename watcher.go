@@ -0,0 +1,119 @@
+package kvstructure
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+)
+
+// Watcher keeps a value of a type up to date with the data in a kv.
+// The value is transdecoded again on every change below the name,
+// and replaced as a whole, so that readers never see a partially
+// decoded value.
+type Watcher struct {
+	name  string
+	typ   reflect.Type
+	td    *transdecoder
+	value atomic.Value
+}
+
+// Update is sent by a Watcher for every change below its name
+type Update struct {
+	// Value is a pointer to the newly transdecoded value,
+	// it is nil if the transdecoding failed
+	Value interface{}
+
+	// Err is the error that happened while transdecoding
+	Err error
+}
+
+// NewWatcher returns a new watcher, which transdecodes the name into
+// values of the type that s is pointing to. The options are the same
+// as the ones of a transdecoder, except for the metadata, which would
+// grow with every update.
+//
+//	w, err := NewWatcher("foo", new(Example),
+//		TransdecoderWithKV(kv),
+//		TransdecoderWithPrefix("prefix"),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//
+//	updates, err := w.Watch(stopCh)
+//	if err != nil {
+//		return err
+//	}
+//
+//	for u := range updates {
+//		example := u.Value.(*Example)
+//	}
+func NewWatcher(name string, s interface{}, opts ...TransdecoderOpt) (*Watcher, error) {
+	val := reflect.ValueOf(s)
+	if val.Kind() != reflect.Ptr {
+		return nil, errors.New("kvstructure: interface must be a pointer")
+	}
+
+	td, err := NewTransdecoder(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if td.(*transdecoder).opts.Metadata != nil {
+		return nil, errors.New("kvstructure: metadata is not supported by a watcher")
+	}
+
+	w := &Watcher{
+		name: name,
+		typ:  val.Type().Elem(),
+		td:   td.(*transdecoder),
+	}
+
+	return w, nil
+}
+
+// Watch watches the kv for changes below the name, and sends an update
+// for every change. The watch is stopped by closing the stopCh,
+// which closes the returned channel.
+func (w *Watcher) Watch(stopCh <-chan struct{}) (<-chan *Update, error) {
+	ch, err := w.td.opts.KV.WatchTree(trailingSlash(w.td.opts.Prefix)+w.name, stopCh)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := make(chan *Update)
+
+	go func() {
+		defer close(updates)
+
+		for kvPairs := range ch {
+			td := w.td.fork()
+			td.snapshot = newSnapshot(trailingSlash(w.td.opts.Prefix)+w.name, kvPairs)
+
+			val := reflect.New(w.typ)
+
+			u := new(Update)
+			if err := td.run(context.Background(), w.name, val.Elem()); err != nil {
+				u.Err = err
+			} else {
+				u.Value = val.Interface()
+				w.value.Store(u.Value)
+			}
+
+			select {
+			case updates <- u:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	return updates, nil
+}
+
+// Load returns a pointer to the current value,
+// it is nil until the first value has been transdecoded
+func (w *Watcher) Load() interface{} {
+	return w.value.Load()
+}
@@ -0,0 +1,68 @@
+package kvstructure_test
+
+import (
+	"testing"
+
+	. "github.com/andersnormal/kvstructure"
+	"github.com/andersnormal/kvstructure/memstore"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatcher(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+	assert.NoError(t, kv.Put("prefix/foo/host", []byte("localhost"), nil))
+	assert.NoError(t, kv.Put("prefix/foo/port", []byte("80"), nil))
+
+	w, err := NewWatcher("foo", new(Backend),
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+	)
+	assert.NoError(t, err)
+	assert.Nil(t, w.Load())
+
+	stopCh := make(chan struct{})
+
+	updates, err := w.Watch(stopCh)
+	assert.NoError(t, err)
+
+	u := <-updates
+	assert.NoError(t, u.Err)
+	assert.Equal(t, &Backend{Host: "localhost", Port: 80}, u.Value)
+	assert.Equal(t, u.Value, w.Load())
+
+	assert.NoError(t, kv.Put("prefix/foo/port", []byte("8080"), nil))
+
+	u = <-updates
+	assert.NoError(t, u.Err)
+	assert.Equal(t, &Backend{Host: "localhost", Port: 8080}, u.Value)
+	assert.Equal(t, u.Value, w.Load())
+
+	assert.NoError(t, kv.Put("prefix/foo/port", []byte("invalid"), nil))
+
+	u = <-updates
+	assert.Error(t, u.Err)
+	assert.Nil(t, u.Value)
+	assert.Equal(t, &Backend{Host: "localhost", Port: 8080}, w.Load())
+
+	close(stopCh)
+
+	for range updates {
+	}
+}
+
+func TestWatcherNoPointer(t *testing.T) {
+	_, err := NewWatcher("foo", Backend{})
+	assert.Error(t, err)
+}
+
+func TestWatcherMetadata(t *testing.T) {
+	kv, _ := memstore.New(nil, nil)
+
+	_, err := NewWatcher("foo", new(Backend),
+		TransdecoderWithKV(kv),
+		TransdecoderWithPrefix("prefix"),
+		TransdecoderWithMetadata(new(Metadata)),
+	)
+	assert.Error(t, err)
+}
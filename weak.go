@@ -0,0 +1,88 @@
+package kvstructure
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// weakParseBool parses the value as a bool with weakly typed input
+func weakParseBool(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "1", "t", "true", "y", "yes", "on":
+		return true, nil
+	case "", "0", "f", "false", "n", "no", "off":
+		return false, nil
+	}
+
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return false, fmt.Errorf("cannot parse '%s' as bool", s)
+	}
+
+	return f != 0, nil
+}
+
+// weakParseFloat parses the value as a float with weakly typed input
+func weakParseFloat(s string, bitSize int) (float64, error) {
+	s = strings.TrimSpace(s)
+
+	if s == "" {
+		return 0, nil
+	}
+
+	if b, err := strconv.ParseBool(s); err == nil {
+		if b {
+			return 1, nil
+		}
+
+		return 0, nil
+	}
+
+	return strconv.ParseFloat(s, bitSize)
+}
+
+// weakParseInt parses the value as an int with weakly typed input.
+// Floats are truncated towards zero.
+func weakParseInt(s string, bitSize int) (int64, error) {
+	if i, err := strconv.ParseInt(strings.TrimSpace(s), 10, bitSize); err == nil {
+		return i, nil
+	}
+
+	f, err := weakParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	f = math.Trunc(f)
+
+	max := math.Ldexp(1, bitSize-1)
+	if math.IsNaN(f) || f < -max || f >= max {
+		return 0, fmt.Errorf("value '%s' out of range", s)
+	}
+
+	return int64(f), nil
+}
+
+// weakParseUint parses the value as an uint with weakly typed input.
+// Floats are truncated towards zero.
+func weakParseUint(s string, bitSize int) (uint64, error) {
+	if i, err := strconv.ParseUint(strings.TrimSpace(s), 10, bitSize); err == nil {
+		return i, nil
+	}
+
+	f, err := weakParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	f = math.Trunc(f)
+
+	max := math.Ldexp(1, bitSize)
+	if math.IsNaN(f) || f < 0 || f >= max {
+		return 0, fmt.Errorf("value '%s' out of range", s)
+	}
+
+	return uint64(f), nil
+}